@@ -4,7 +4,7 @@
  * @Date: 2022-06-06 23:40:38
  * @FilePath: /consistent_hash/all_test.go
  */
-package main
+package consistenthash
 
 import (
 	"fmt"
@@ -18,6 +18,7 @@ import (
 
 	jump "github.com/shanyux/consistent_hash/go_jump_consistent_hash"
 	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+	maglev "github.com/shanyux/consistent_hash/go_maglev_consistent_hash"
 	rendezvous "github.com/shanyux/consistent_hash/go_rendezvous_consistent_hash"
 )
 
@@ -158,6 +159,89 @@ func JumpConsistentHash(nodeNum, testCount int) {
 	fmt.Printf("标准差:%f, run time:%f\n", getStandardDeviation(values), time.Since(start).Seconds())
 }
 
+func MaglevConsistentHash(nodeNum, testCount int) {
+	distributeMap := make(map[string]int64)
+	nodes := getServerNodes(nodeNum)
+	backends := make([]maglev.Backend, 0, nodeNum)
+	for _, s := range nodes {
+		distributeMap[s] = 0
+		backends = append(backends, maglev.Backend{Name: s, Weight: 1})
+	}
+	mg, _ := maglev.New(backends, maglev.DefaultSize, hashString)
+	start := time.Now()
+
+	for i := 0; i < testCount; i++ {
+		testName := "testName"
+		node := mg.Lookup(testName + strconv.Itoa(i))
+		distributeMap[node] = distributeMap[node] + 1
+	}
+
+	var values []float64
+	fmt.Printf("****MaglevConsistentHash 测试%d个结点,%d条测试数据\n", nodeNum, testCount)
+	for _, v := range distributeMap {
+		values = append(values, float64(v))
+	}
+	fmt.Printf("标准差:%f, run time:%f\n", getStandardDeviation(values), time.Since(start).Seconds())
+}
+
+// getDisruption 返回删除 removed 结点后，原本不在 removed 上却发生迁移的 key 的比例
+func getDisruption(before, after func(key string) string, removed string, testCount int) float64 {
+	moved, total := 0, 0
+	for i := 0; i < testCount; i++ {
+		key := "testName" + strconv.Itoa(i)
+		b := before(key)
+		if b == removed {
+			continue
+		}
+		total++
+		if after(key) != b {
+			moved++
+		}
+	}
+	return float64(moved) / float64(total)
+}
+
+func Test_All_Disruption(t *testing.T) {
+	nodeNumList := []int{10, 50, 100, 200}
+	testCount := 100000
+	for _, nodeNum := range nodeNumList {
+		nodes := getServerNodes(nodeNum)
+		removed := nodes[nodeNum/2]
+
+		ketamaNodes := getKatamaNodes(uint(nodeNum), 1)
+		ring := ketama.NewRing(ketamaNodes)
+		ketamaBefore := make(map[string]string, testCount)
+		for i := 0; i < testCount; i++ {
+			key := "testName" + strconv.Itoa(i)
+			ketamaBefore[key] = ring.Get(key).Key()
+		}
+		ring.Remove(removed)
+		ketamaDisruption := getDisruption(func(key string) string { return ketamaBefore[key] },
+			func(key string) string { return ring.Get(key).Key() }, removed, testCount)
+
+		rdz := rendezvous.NewRendezvous(nodes, hashString)
+		rdzAfter := rendezvous.NewRendezvous(nodes, hashString)
+		rdzAfter.Remove(removed)
+		rdzDisruption := getDisruption(rdz.Lookup, rdzAfter.Lookup, removed, testCount)
+
+		backends := make([]maglev.Backend, 0, nodeNum)
+		for _, s := range nodes {
+			backends = append(backends, maglev.Backend{Name: s, Weight: 1})
+		}
+		mg, _ := maglev.New(backends, maglev.DefaultSize, hashString)
+		mgAfter, _ := maglev.New(backends, maglev.DefaultSize, hashString)
+		mgAfter.Remove(removed)
+		mgDisruption := getDisruption(mg.Lookup, mgAfter.Lookup, removed, testCount)
+
+		fmt.Printf("删除%d个结点中的一个,%d条测试数据\n", nodeNum, testCount)
+		fmt.Printf("ketama 迁移比例:%f, rendezvous 迁移比例:%f, maglev 迁移比例:%f\n\n",
+			ketamaDisruption, rdzDisruption, mgDisruption)
+		if ketamaDisruption != 0 || rdzDisruption != 0 || mgDisruption != 0 {
+			t.Errorf("ketama, rendezvous and maglev must only move keys of the removed node")
+		}
+	}
+}
+
 func Test_All_ConsistentHash(t *testing.T) {
 	nodeNumList := []int{50, 100, 200, 300, 400, 500, 600, 700, 800, 900}
 	//测试10台服务器
@@ -170,6 +254,7 @@ func Test_All_ConsistentHash(t *testing.T) {
 			KatamaConsistentHash(nodeNum, v, testCount)
 		}
 		RendezvousConsistentHash(nodeNum, testCount)
+		MaglevConsistentHash(nodeNum, testCount)
 		JumpConsistentHash(nodeNum, testCount)
 		fmt.Println()
 	}
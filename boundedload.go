@@ -0,0 +1,109 @@
+package consistenthash
+
+import (
+	"math"
+	"sync"
+)
+
+// BoundedLoad implements consistent hashing with bounded loads[1] on top of
+// any Picker. No node gets more than ceil((1+epsilon) * average) requests
+// in flight: when the node of a key is full the request falls through the
+// key's LookupN preference order, that is the next points clockwise for
+// ketama, the next highest scores for rendezvous and the rehash chain for
+// jump.
+//
+// [1] https://arxiv.org/pdf/1608.01350.pdf
+type BoundedLoad struct {
+	picker  Picker
+	epsilon float64
+
+	mu    sync.Mutex
+	loads map[string]int64
+	total int64
+}
+
+// NewBoundedLoad returns a BoundedLoad over p. epsilon is the allowed
+// overload, 0.25 lets a node take 25% more than the average load.
+func NewBoundedLoad(p Picker, epsilon float64) *BoundedLoad {
+	if epsilon < 0 {
+		epsilon = 0
+	}
+	return &BoundedLoad{
+		picker:  p,
+		epsilon: epsilon,
+		loads:   make(map[string]int64),
+	}
+}
+
+// Acquire returns the node serving key and counts one more request on it.
+// Every Acquire must be followed by a Release of the returned node. It
+// returns "" if the picker has no nodes.
+func (b *BoundedLoad) Acquire(key string) string {
+	node := b.picker.Lookup(key)
+	if node == "" {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := b.picker.Len()
+	limit := b.capacity(count)
+	if b.loads[node] < limit {
+		b.acquire(node)
+		return node
+	}
+	for _, n := range b.picker.LookupN(key, count) {
+		if b.loads[n] < limit {
+			b.acquire(n)
+			return n
+		}
+	}
+	// The limit is above the average, so some node is always below it
+	// unless the membership changed in between.
+	b.acquire(node)
+	return node
+}
+
+func (b *BoundedLoad) acquire(node string) {
+	b.loads[node]++
+	b.total++
+}
+
+// Release ends a request on node.
+func (b *BoundedLoad) Release(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.loads[node] <= 0 {
+		return
+	}
+	b.loads[node]--
+	b.total--
+	if b.loads[node] == 0 {
+		delete(b.loads, node)
+	}
+}
+
+// Load returns the requests in flight on node.
+func (b *BoundedLoad) Load(node string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loads[node]
+}
+
+// Capacity returns how many requests a node may have in flight before the
+// next request skips it.
+func (b *BoundedLoad) Capacity() int64 {
+	n := b.picker.Len()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.capacity(n)
+}
+
+// capacity returns ceil((1+epsilon) * (total+1) / nodes), counting the
+// request being placed. b.mu must be held.
+func (b *BoundedLoad) capacity(nodes int) int64 {
+	if nodes == 0 {
+		return 0
+	}
+	return int64(math.Ceil((1 + b.epsilon) * float64(b.total+1) / float64(nodes)))
+}
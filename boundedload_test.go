@@ -0,0 +1,126 @@
+package consistenthash
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// zipfKeys returns count keys drawn from a Zipf distribution, the first
+// key being by far the hottest.
+func zipfKeys(count int) []string {
+	z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 10000)
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = "key" + strconv.FormatUint(z.Uint64(), 10)
+	}
+	return keys
+}
+
+func TestBoundedLoadSkewed(t *testing.T) {
+	nodes := getServerNodes(20)
+	keys := zipfKeys(20000)
+	epsilon := 0.25
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, nil)
+
+		plain := make(map[string]int)
+		plainMax := 0
+		for _, k := range keys {
+			n := p.Lookup(k)
+			plain[n]++
+			if plain[n] > plainMax {
+				plainMax = plain[n]
+			}
+		}
+
+		b := NewBoundedLoad(p, epsilon)
+		moved := 0
+		for _, k := range keys {
+			if b.Acquire(k) != p.Lookup(k) {
+				moved++
+			}
+		}
+		limit := int64(math.Ceil((1 + epsilon) * float64(len(keys)) / float64(len(nodes))))
+		var boundedMax int64
+		for _, n := range nodes {
+			if l := b.Load(n); l > boundedMax {
+				boundedMax = l
+			}
+		}
+		if boundedMax > limit {
+			t.Errorf("%s: max load %d above the bound %d", name, boundedMax, limit)
+		}
+		if plainMax <= int(limit) {
+			t.Errorf("%s: the workload is not skewed enough, plain max load %d", name, plainMax)
+		}
+		t.Logf("%s: max load %d without bound, %d with epsilon %.2f, %d of %d requests fell through",
+			name, plainMax, boundedMax, epsilon, moved, len(keys))
+	}
+}
+
+func TestBoundedLoadPreference(t *testing.T) {
+	nodes := getServerNodes(5)
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, nil)
+		b := NewBoundedLoad(p, 0)
+		// With epsilon 0 and one request per node in flight, a key falls
+		// through to its first preference with room.
+		for _, n := range nodes {
+			b.loads[n] = 1
+			b.total++
+		}
+		pref := p.LookupN("key", len(nodes))
+		b.loads[pref[0]] = 3
+		b.loads[pref[1]] = 3
+		b.total += 4
+		if n := b.Acquire("key"); n != pref[2] {
+			t.Errorf("%s: expected %s, got %s", name, pref[2], n)
+		}
+	}
+}
+
+func TestBoundedLoadConcurrent(t *testing.T) {
+	nodes := getServerNodes(10)
+	p, _ := New(Rendezvous, nodes, nil)
+	b := NewBoundedLoad(p, 0.1)
+	keys := zipfKeys(10000)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(keys); i += 8 {
+				n := b.Acquire(keys[i])
+				if n == "" {
+					t.Error("no node acquired")
+					return
+				}
+				b.Release(n)
+			}
+		}(g)
+	}
+	wg.Wait()
+	for _, n := range nodes {
+		if l := b.Load(n); l != 0 {
+			t.Errorf("%s still has load %d", n, l)
+		}
+	}
+	b.Release(nodes[0])
+	if l := b.Load(nodes[0]); l != 0 {
+		t.Errorf("release without acquire changed the load to %d", l)
+	}
+}
+
+func TestBoundedLoadEmpty(t *testing.T) {
+	b := NewBoundedLoad(NewJump(nil, nil), 0.25)
+	if n := b.Acquire("key"); n != "" {
+		t.Errorf("expected no node, got %q", n)
+	}
+	if c := b.Capacity(); c != 0 {
+		t.Errorf("expected capacity 0, got %d", c)
+	}
+}
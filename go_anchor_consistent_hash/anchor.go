@@ -0,0 +1,140 @@
+// Package anchor implements AnchorHash[1], a consistent hash that supports
+// removing arbitrary buckets with constant expected lookup time and a few
+// integers of memory per bucket.
+//
+// The number of buckets is bounded by a fixed capacity, the anchor set. A
+// removed bucket remembers the size of the working set at the time it was
+// removed, and keys hashing to it are rehashed onto the buckets that were
+// still working back then. Adding a bucket undoes the latest removal.
+//
+// [1] https://arxiv.org/pdf/1812.09674.pdf
+package anchor
+
+import (
+	"errors"
+
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+var (
+	// ErrFull is returned when adding a bucket while every bucket of the
+	// anchor set is working.
+	ErrFull = errors.New("anchor: all buckets are working")
+	// ErrNotWorking is returned when removing a bucket that is not working.
+	ErrNotWorking = errors.New("anchor: bucket is not working")
+	// ErrLastBucket is returned when removing the only working bucket.
+	ErrLastBucket = errors.New("anchor: cannot remove the last working bucket")
+)
+
+// Anchor is an AnchorHash over the buckets [0, capacity).
+// It is not safe for concurrent use.
+type Anchor struct {
+	// a holds for each removed bucket the size of the working set right
+	// after its removal, and 0 for working buckets.
+	a []uint32
+	// w is the working set, w[:n] are the working buckets.
+	w []uint32
+	// l is the position of each bucket in w.
+	l []uint32
+	// k is the bucket that took the position of each removed bucket in w.
+	k []uint32
+	// r is the stack of removed buckets.
+	r []uint32
+	n uint32
+}
+
+// NewAnchor returns an Anchor with capacity buckets of which the first
+// working ones, [0, working), are working. working is clamped to
+// [1, capacity].
+func NewAnchor(capacity, working int) *Anchor {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if working < 1 {
+		working = 1
+	}
+	if working > capacity {
+		working = capacity
+	}
+	h := &Anchor{
+		a: make([]uint32, capacity),
+		w: make([]uint32, capacity),
+		l: make([]uint32, capacity),
+		k: make([]uint32, capacity),
+		r: make([]uint32, 0, capacity),
+		n: uint32(working),
+	}
+	for b := capacity - 1; b >= working; b-- {
+		h.r = append(h.r, uint32(b))
+		h.a[b] = uint32(b)
+	}
+	for b := 0; b < capacity; b++ {
+		h.w[b] = uint32(b)
+		h.l[b] = uint32(b)
+		h.k[b] = uint32(b)
+	}
+	return h
+}
+
+// Capacity returns the size of the anchor set.
+func (h *Anchor) Capacity() int {
+	return len(h.a)
+}
+
+// Len returns the number of working buckets.
+func (h *Anchor) Len() int {
+	return int(h.n)
+}
+
+// Working reports whether bucket b is working.
+func (h *Anchor) Working(b int) bool {
+	return b >= 0 && b < len(h.a) && h.a[b] == 0
+}
+
+// GetBucket returns the working bucket of key.
+func (h *Anchor) GetBucket(key uint64) int {
+	b := uint32(splitmix64.Mix(key) % uint64(len(h.a)))
+	for h.a[b] > 0 {
+		// b was removed, rehash onto the buckets working at that time.
+		c := uint32(splitmix64.Mix(key^(uint64(b)+1)*splitmix64.Gamma) % uint64(h.a[b]))
+		for h.a[c] >= h.a[b] {
+			// c was removed before b, follow its replacement.
+			c = h.k[c]
+		}
+		b = c
+	}
+	return int(b)
+}
+
+// Add makes the most recently removed bucket working again and returns it.
+func (h *Anchor) Add() (int, error) {
+	if len(h.r) == 0 {
+		return 0, ErrFull
+	}
+	b := h.r[len(h.r)-1]
+	h.r = h.r[:len(h.r)-1]
+	h.a[b] = 0
+	h.l[h.w[h.n]] = h.n
+	h.w[h.l[b]] = b
+	h.k[b] = b
+	h.n++
+	return int(b), nil
+}
+
+// Remove removes working bucket b. Only the keys of b move.
+func (h *Anchor) Remove(b int) error {
+	if !h.Working(b) {
+		return ErrNotWorking
+	}
+	if h.n == 1 {
+		return ErrLastBucket
+	}
+	ub := uint32(b)
+	h.r = append(h.r, ub)
+	h.n--
+	h.a[ub] = h.n
+	h.w[h.l[ub]] = h.w[h.n]
+	h.l[h.w[h.n]] = h.l[ub]
+	h.k[ub] = h.w[h.n]
+	return nil
+}
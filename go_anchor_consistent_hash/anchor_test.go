@@ -0,0 +1,196 @@
+package anchor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func TestAnchorErrors(t *testing.T) {
+	h := NewAnchor(4, 4)
+	if _, err := h.Add(); err != ErrFull {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+	if err := h.Remove(4); err != ErrNotWorking {
+		t.Errorf("expected ErrNotWorking, got %v", err)
+	}
+	for b := 0; b < 3; b++ {
+		if err := h.Remove(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Remove(0); err != ErrNotWorking {
+		t.Errorf("expected ErrNotWorking, got %v", err)
+	}
+	if err := h.Remove(3); err != ErrLastBucket {
+		t.Errorf("expected ErrLastBucket, got %v", err)
+	}
+}
+
+func TestAnchorBalance(t *testing.T) {
+	h := NewAnchor(1000, 100)
+	// Remove a random half of the working buckets.
+	r := rand.New(rand.NewSource(1))
+	for h.Len() > 50 {
+		h.Remove(r.Intn(100))
+	}
+	count := make(map[int]int)
+	testCount := 500000
+	for i := 0; i < testCount; i++ {
+		b := h.GetBucket(hashString("key" + strconv.Itoa(i)))
+		if !h.Working(b) {
+			t.Fatalf("key%d mapped to removed bucket %d", i, b)
+		}
+		count[b]++
+	}
+	avg := float64(testCount) / 50
+	for b, n := range count {
+		if float64(n) < avg*0.9 || float64(n) > avg*1.1 {
+			t.Errorf("bucket %d got %d keys, expected about %.0f", b, n, avg)
+		}
+	}
+}
+
+func TestAnchorMinimalDisruption(t *testing.T) {
+	h := NewAnchor(64, 32)
+	keys := make([]uint64, 50000)
+	for i := range keys {
+		keys[i] = hashString("key" + strconv.Itoa(i))
+	}
+	snapshot := func() []int {
+		res := make([]int, len(keys))
+		for i, k := range keys {
+			res[i] = h.GetBucket(k)
+		}
+		return res
+	}
+
+	var history [][]int
+	for _, b := range []int{5, 17, 0, 31, 12} {
+		before := snapshot()
+		history = append(history, before)
+		h.Remove(b)
+		for i, after := range snapshot() {
+			if before[i] != b && after != before[i] {
+				t.Fatalf("removing %d moved key %d from %d to %d", b, i, before[i], after)
+			}
+			if after == b {
+				t.Fatalf("key %d still on removed bucket %d", i, b)
+			}
+		}
+	}
+
+	// Adding undoes the removals in LIFO order.
+	for _, b := range []int{12, 31, 0, 17, 5} {
+		added, err := h.Add()
+		if err != nil || added != b {
+			t.Fatalf("expected to add back %d, got %d, %v", b, added, err)
+		}
+		expected := history[len(history)-1]
+		history = history[:len(history)-1]
+		for i, after := range snapshot() {
+			if after != expected[i] {
+				t.Fatalf("adding %d moved key %d to %d, expected %d", b, i, after, expected[i])
+			}
+		}
+	}
+
+	// Growing past the initial working set only moves keys to new buckets.
+	before := snapshot()
+	added, _ := h.Add()
+	for i, after := range snapshot() {
+		if after != before[i] && after != added {
+			t.Fatalf("adding %d moved key %d from %d to %d", added, i, before[i], after)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("127.0.0.1:800%d", i)
+	}
+	n, err := NewNamed(16, nodes, hashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNamed(4, nodes, hashString); err != ErrFull {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+	if err := n.Add(nodes[0]); err != ErrNodeExists {
+		t.Errorf("expected ErrNodeExists, got %v", err)
+	}
+	if err := n.Remove("unknown"); err != ErrNodeNotFound {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = n.Lookup(key)
+	}
+	n.Remove(nodes[4])
+	if len(n.Nodes()) != 9 {
+		t.Fatalf("expected 9 nodes, got %v", n.Nodes())
+	}
+	for key, node := range before {
+		if after := n.Lookup(key); node != nodes[4] && after != node || after == nodes[4] {
+			t.Errorf("key %s moved from %s to %s", key, node, after)
+		}
+	}
+	// A new node takes over the removed node's bucket and keys.
+	n.Add("127.0.0.1:9000")
+	for key, node := range before {
+		after := n.Lookup(key)
+		if node == nodes[4] && after != "127.0.0.1:9000" || node != nodes[4] && after != node {
+			t.Errorf("key %s moved from %s to %s", key, node, after)
+		}
+	}
+}
+
+func TestNamedEmpty(t *testing.T) {
+	n, _ := NewNamed(4, nil, hashString)
+	if node := n.Lookup("key"); node != "" {
+		t.Errorf("expected empty lookup, got %q", node)
+	}
+	n.Add("a")
+	n.Add("b")
+	n.Remove("a")
+	n.Remove("b")
+	if node := n.Lookup("key"); node != "" || len(n.Nodes()) != 0 {
+		t.Errorf("expected no nodes, got %q, %v", node, n.Nodes())
+	}
+	n.Add("c")
+	if node := n.Lookup("key"); node != "c" {
+		t.Errorf("expected c, got %q", node)
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	prefix := func(key string) string { return key[:4] }
+	m, _ := NewNamed(16, []string{"a", "b", "c", "d"}, hashString, WithKeyExtractor(prefix))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i%100)
+		if m.Lookup(key+"profile") != m.Lookup(key+"cart") {
+			t.Errorf("keys with prefix %s are on different nodes", key)
+		}
+	}
+}
+
+func BenchmarkGetBucket(b *testing.B) {
+	h := NewAnchor(1000, 100)
+	for i := 0; i < 50; i++ {
+		h.Remove(i * 2)
+	}
+	for i := 0; i < b.N; i++ {
+		h.GetBucket(uint64(i))
+	}
+}
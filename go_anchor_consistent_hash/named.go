@@ -0,0 +1,122 @@
+package anchor
+
+import "errors"
+
+var (
+	// ErrNodeExists is returned when adding a node that is already present.
+	ErrNodeExists = errors.New("anchor: node already exists")
+	// ErrNodeNotFound is returned when removing an unknown node.
+	ErrNodeNotFound = errors.New("anchor: node not found")
+)
+
+// Hasher hashes a key to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Named maps keys to named nodes on top of an Anchor. Each node owns one
+// bucket; a new node takes the bucket of the most recently removed one.
+// It is not safe for concurrent use.
+type Named struct {
+	anchor  *Anchor
+	hash    Hasher
+	names   []string
+	buckets map[string]int
+	extract func(key string) string
+}
+
+// Option configures a Named.
+type Option func(n *Named)
+
+// WithKeyExtractor makes lookups hash extract(key) instead of key, so keys
+// sharing a hash tag land on the same node.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(n *Named) {
+		n.extract = extract
+	}
+}
+
+// NewNamed returns a Named with room for capacity nodes. Without nodes
+// the anchor still has one working bucket, it is named by the first Add.
+func NewNamed(capacity int, nodes []string, hash Hasher, opts ...Option) (*Named, error) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if len(nodes) > capacity {
+		return nil, ErrFull
+	}
+	n := &Named{
+		anchor:  NewAnchor(capacity, len(nodes)),
+		hash:    hash,
+		names:   make([]string, capacity),
+		buckets: make(map[string]int, len(nodes)),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	for b, node := range nodes {
+		if _, ok := n.buckets[node]; ok {
+			return nil, ErrNodeExists
+		}
+		n.names[b] = node
+		n.buckets[node] = b
+	}
+	return n, nil
+}
+
+// Lookup returns the node of key, or "" if there are no nodes.
+func (n *Named) Lookup(key string) string {
+	if len(n.buckets) == 0 {
+		return ""
+	}
+	if n.extract != nil {
+		key = n.extract(key)
+	}
+	return n.names[n.anchor.GetBucket(n.hash(key))]
+}
+
+// Nodes returns the current nodes.
+func (n *Named) Nodes() []string {
+	res := make([]string, 0, len(n.buckets))
+	if len(n.buckets) == 0 {
+		return res
+	}
+	for _, b := range n.anchor.w[:n.anchor.n] {
+		res = append(res, n.names[b])
+	}
+	return res
+}
+
+// Add adds node.
+func (n *Named) Add(node string) error {
+	if _, ok := n.buckets[node]; ok {
+		return ErrNodeExists
+	}
+	var b int
+	if len(n.buckets) == 0 {
+		// The anchor keeps one bucket working even without nodes.
+		b = int(n.anchor.w[0])
+	} else {
+		var err error
+		if b, err = n.anchor.Add(); err != nil {
+			return err
+		}
+	}
+	n.names[b] = node
+	n.buckets[node] = b
+	return nil
+}
+
+// Remove removes node. Only the keys of node move.
+func (n *Named) Remove(node string) error {
+	b, ok := n.buckets[node]
+	if !ok {
+		return ErrNodeNotFound
+	}
+	if len(n.buckets) > 1 {
+		if err := n.anchor.Remove(b); err != nil {
+			return err
+		}
+	}
+	n.names[b] = ""
+	delete(n.buckets, node)
+	return nil
+}
@@ -0,0 +1,183 @@
+// Package copyset implements copyset replication[1]. Placing each replica
+// independently, as ketama's GetN does, uses almost every possible set of
+// nodes as a replica set, so losing any few nodes at once loses data.
+// Copysets restricts the replica sets to a few groups: nodes are permuted
+// scatterWidth/(replicas-1) times, each permutation is cut into groups of
+// replicas nodes, and a key is stored on one of the groups of its primary
+// node. Losing data then needs a whole group to fail.
+//
+// [1] https://www.usenix.org/system/files/conference/atc13/atc13-cidon.pdf
+package copyset
+
+import (
+	"errors"
+	"sort"
+
+	rendezvous "github.com/shanyux/consistent_hash/go_rendezvous_consistent_hash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+var (
+	// ErrInvalidReplicas is returned for replicas outside [1, len(nodes)].
+	ErrInvalidReplicas = errors.New("copyset: invalid number of replicas")
+	// ErrInvalidScatterWidth is returned for a scatter width smaller than
+	// replicas-1.
+	ErrInvalidScatterWidth = errors.New("copyset: invalid scatter width")
+)
+
+// Hasher hashes a key or a node name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Copysets maps keys to replica groups.
+// It is not safe for concurrent use.
+type Copysets struct {
+	replicas int
+	hash     Hasher
+	primary  *rendezvous.Rendezvous
+	sets     [][]string
+	setHash  []uint64
+	byNode   map[string][]int
+}
+
+// New returns the copysets of nodes for the given number of replicas and
+// scatter width, the number of other nodes each node shares replica groups
+// with. The permutations are derived from hash, so the same nodes always
+// give the same groups.
+func New(nodes []string, replicas, scatterWidth int, hash Hasher) (*Copysets, error) {
+	if replicas < 1 || replicas > len(nodes) {
+		return nil, ErrInvalidReplicas
+	}
+	if scatterWidth < replicas-1 {
+		return nil, ErrInvalidScatterWidth
+	}
+	c := &Copysets{
+		replicas: replicas,
+		hash:     hash,
+		primary:  rendezvous.NewRendezvous(nodes, rendezvous.Hasher(hash)),
+		byNode:   make(map[string][]int, len(nodes)),
+	}
+	permutations := 1
+	if replicas > 1 {
+		permutations = (scatterWidth + replicas - 2) / (replicas - 1)
+	}
+	for p := 0; p < permutations; p++ {
+		for _, set := range groups(permute(nodes, p, hash), replicas) {
+			c.add(set)
+		}
+	}
+	return c, nil
+}
+
+// permute returns the p-th permutation of nodes. The node hashes are
+// mixed with p, salting the hashed string is not enough for hashes like
+// FNV whose order barely changes with a common prefix.
+func permute(nodes []string, p int, hash Hasher) []string {
+	keys := make(map[string]uint64, len(nodes))
+	res := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[node] = splitmix64.Mix(hash(node) + uint64(p)*splitmix64.Gamma)
+		res[i] = node
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if keys[res[i]] != keys[res[j]] {
+			return keys[res[i]] < keys[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// groups cuts perm into groups of size nodes. If size does not divide
+// len(perm), the last group is the last size nodes so that every node is
+// in a group.
+func groups(perm []string, size int) [][]string {
+	var res [][]string
+	for i := 0; i < len(perm); i += size {
+		if i+size > len(perm) {
+			i = len(perm) - size
+		}
+		res = append(res, perm[i:i+size])
+	}
+	return res
+}
+
+// add adds set unless it is already a copyset.
+func (c *Copysets) add(set []string) {
+	sorted := make([]string, len(set))
+	copy(sorted, set)
+	sort.Strings(sorted)
+	id := ""
+	for _, node := range sorted {
+		id += node + "\x00"
+	}
+	for _, i := range c.byNode[sorted[0]] {
+		if equal(c.sets[i], sorted) {
+			return
+		}
+	}
+	for _, node := range sorted {
+		c.byNode[node] = append(c.byNode[node], len(c.sets))
+	}
+	c.sets = append(c.sets, sorted)
+	c.setHash = append(c.setHash, c.hash(id))
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the replicas of key, its primary node first. The primary
+// is chosen by rendezvous hashing and the group among those of the primary
+// by rendezvous hashing too, so keys are spread over all groups.
+// Returns nil if there are no nodes.
+func (c *Copysets) Lookup(key string) []string {
+	primary := c.primary.Lookup(key)
+	if primary == "" {
+		return nil
+	}
+	keyHash := c.hash(key)
+	best := -1
+	var bestScore uint64
+	for _, i := range c.byNode[primary] {
+		if s := rendezvous.Score(keyHash, c.setHash[i]); best < 0 || s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	res := make([]string, 0, c.replicas)
+	res = append(res, primary)
+	for _, node := range c.sets[best] {
+		if node != primary {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// Copysets returns the replica groups, each sorted.
+func (c *Copysets) Copysets() [][]string {
+	res := make([][]string, len(c.sets))
+	for i, set := range c.sets {
+		res[i] = make([]string, len(set))
+		copy(res[i], set)
+	}
+	return res
+}
+
+// ScatterWidth returns the number of distinct other nodes node shares a
+// group with.
+func (c *Copysets) ScatterWidth(node string) int {
+	peers := make(map[string]bool)
+	for _, i := range c.byNode[node] {
+		for _, n := range c.sets[i] {
+			if n != node {
+				peers[n] = true
+			}
+		}
+	}
+	return len(peers)
+}
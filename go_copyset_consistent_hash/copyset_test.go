@@ -0,0 +1,152 @@
+package copyset
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getServerNodes(nodenum int) []string {
+	nodes := make([]string, 0, nodenum)
+	for i := 0; i < nodenum; i++ {
+		nodes = append(nodes, fmt.Sprintf("127.0.0.1:80%02d", i))
+	}
+	return nodes
+}
+
+func TestNew(t *testing.T) {
+	nodes := getServerNodes(5)
+	if _, err := New(nodes, 6, 4, hashString); err != ErrInvalidReplicas {
+		t.Errorf("expected ErrInvalidReplicas, got %v", err)
+	}
+	if _, err := New(nodes, 0, 4, hashString); err != ErrInvalidReplicas {
+		t.Errorf("expected ErrInvalidReplicas, got %v", err)
+	}
+	if _, err := New(nodes, 3, 1, hashString); err != ErrInvalidScatterWidth {
+		t.Errorf("expected ErrInvalidScatterWidth, got %v", err)
+	}
+}
+
+func TestCopysets(t *testing.T) {
+	nodes := getServerNodes(31)
+	c, err := New(nodes, 3, 4, hashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := New(nodes, 3, 4, hashString)
+	sets := c.Copysets()
+	// Two permutations of 11 groups, the last one overlapping.
+	if len(sets) > 22 || len(sets) != len(again.Copysets()) {
+		t.Fatalf("expected at most 22 copysets, got %d", len(sets))
+	}
+	ids := make(map[string]bool)
+	for i, set := range sets {
+		if !sort.StringsAreSorted(set) || len(set) != 3 || strings.Join(set, ",") != strings.Join(again.Copysets()[i], ",") {
+			t.Fatalf("unexpected copyset %v", set)
+		}
+		ids[strings.Join(set, ",")] = true
+	}
+	for _, node := range nodes {
+		if w := c.ScatterWidth(node); w < 2 || w > 6 {
+			t.Errorf("%s has scatter width %d", node, w)
+		}
+	}
+
+	used := make(map[string]bool)
+	primary := make(map[string]int)
+	for i := 0; i < 100000; i++ {
+		replicas := c.Lookup(strconv.Itoa(i))
+		if len(replicas) != 3 {
+			t.Fatalf("expected 3 replicas, got %v", replicas)
+		}
+		primary[replicas[0]]++
+		set := append([]string(nil), replicas...)
+		sort.Strings(set)
+		id := strings.Join(set, ",")
+		if !ids[id] {
+			t.Fatalf("%v is not a copyset", replicas)
+		}
+		used[id] = true
+	}
+	if len(used) != len(ids) || len(primary) != len(nodes) {
+		t.Errorf("expected keys on all %d copysets and %d primaries, got %d and %d", len(ids), len(nodes), len(used), len(primary))
+	}
+}
+
+// lossProbability returns the probability that failures random nodes
+// failing at once include all the nodes of one of sets.
+func lossProbability(sets [][]string, nodes []string, failures, trials int) float64 {
+	rnd := rand.New(rand.NewSource(1))
+	lost := 0
+	for i := 0; i < trials; i++ {
+		failed := make(map[string]bool, failures)
+		for _, j := range rnd.Perm(len(nodes))[:failures] {
+			failed[nodes[j]] = true
+		}
+		for _, set := range sets {
+			all := true
+			for _, node := range set {
+				all = all && failed[node]
+			}
+			if all {
+				lost++
+				break
+			}
+		}
+	}
+	return float64(lost) / float64(trials)
+}
+
+func TestLossProbability(t *testing.T) {
+	nodes := getServerNodes(30)
+	c, _ := New(nodes, 3, 4, hashString)
+	var ketamaNodes []*ketama.Node
+	for _, node := range nodes {
+		ketamaNodes = append(ketamaNodes, ketama.NewNode(node, nil, 1))
+	}
+	ring := ketama.NewRing(ketamaNodes)
+
+	// The distinct replica sets of 100000 keys.
+	copysets := make(map[string][]string)
+	getN := make(map[string][]string)
+	for i := 0; i < 100000; i++ {
+		key := strconv.Itoa(i)
+		replicas := c.Lookup(key)
+		sort.Strings(replicas)
+		copysets[strings.Join(replicas, ",")] = replicas
+		replicas = nil
+		for _, n := range ring.GetN(key, 3) {
+			replicas = append(replicas, n.Key())
+		}
+		sort.Strings(replicas)
+		getN[strings.Join(replicas, ",")] = replicas
+	}
+	values := func(m map[string][]string) [][]string {
+		var res [][]string
+		for _, set := range m {
+			res = append(res, set)
+		}
+		return res
+	}
+	for _, failures := range []int{3, 5} {
+		pc := lossProbability(values(copysets), nodes, failures, 20000)
+		pg := lossProbability(values(getN), nodes, failures, 20000)
+		fmt.Printf("%d failures: copyset (%d sets) loss %f, GetN (%d sets) loss %f\n",
+			failures, len(copysets), pc, len(getN), pg)
+		if pc > pg/5 {
+			t.Errorf("%d failures: expected copysets to lose data much less often than GetN, got %f and %f", failures, pc, pg)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package crush implements CRUSH-style hierarchical placement[1] with straw2
+// buckets. Devices sit at the leaves of a weighted tree such as
+// datacenter -> rack -> host -> disk, and rules like
+//
+//	take root
+//	chooseleaf firstn 0 type rack
+//	emit
+//
+// pick replicas in distinct failure domains.
+//
+// Straw2 is weighted rendezvous hashing: every child draws ln(u)/weight,
+// u being its rendezvous score scaled to (0, 1], and the highest draw wins.
+// Changing the weight of one child only moves data from or to that child.
+//
+// [1] https://ceph.com/assets/pdfs/weil-crush-sc06.pdf
+package crush
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	rendezvous "github.com/shanyux/consistent_hash/go_rendezvous_consistent_hash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// DefaultTries is the number of attempts made for a replica before giving
+// up on it.
+const DefaultTries = 50
+
+var (
+	// ErrDuplicateName is returned when two buckets share a name.
+	ErrDuplicateName = errors.New("crush: duplicate bucket name")
+	// ErrBucketNotFound is returned for an unknown bucket name.
+	ErrBucketNotFound = errors.New("crush: bucket not found")
+	// ErrInvalidWeight is returned for a negative or NaN weight.
+	ErrInvalidWeight = errors.New("crush: invalid weight")
+)
+
+// Hasher hashes a key or a bucket name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Bucket is a node of the topology. A bucket without children is a device;
+// the weight of other buckets is the sum of their children's weights.
+type Bucket struct {
+	Name     string
+	Type     string
+	Weight   float64
+	Children []*Bucket
+
+	hash   uint64
+	parent *Bucket
+}
+
+// IsDevice reports whether b is a leaf of the topology.
+func (b *Bucket) IsDevice() bool {
+	return len(b.Children) == 0
+}
+
+// Map is a placement map over a bucket tree.
+// It is not safe for concurrent use.
+type Map struct {
+	root    *Bucket
+	hash    Hasher
+	buckets map[string]*Bucket
+	// Tries is the number of attempts made for a replica. It defaults to
+	// DefaultTries.
+	Tries int
+}
+
+// NewMap returns a Map over the tree rooted at root. The tree is owned by
+// the Map afterwards, use SetWeight to change it.
+func NewMap(root *Bucket, hash Hasher) (*Map, error) {
+	m := &Map{
+		root:    root,
+		hash:    hash,
+		buckets: make(map[string]*Bucket),
+		Tries:   DefaultTries,
+	}
+	if err := m.index(root, nil); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Map) index(b, parent *Bucket) error {
+	if _, ok := m.buckets[b.Name]; ok {
+		return ErrDuplicateName
+	}
+	m.buckets[b.Name] = b
+	b.hash = m.hash(b.Name)
+	b.parent = parent
+	if b.IsDevice() {
+		if b.Weight < 0 || math.IsNaN(b.Weight) {
+			return ErrInvalidWeight
+		}
+		return nil
+	}
+	b.Weight = 0
+	for _, c := range b.Children {
+		if err := m.index(c, b); err != nil {
+			return err
+		}
+		b.Weight += c.Weight
+	}
+	return nil
+}
+
+// Bucket returns the bucket named name, or nil.
+func (m *Map) Bucket(name string) *Bucket {
+	return m.buckets[name]
+}
+
+// SetWeight sets the weight of the device named name and updates the
+// weights of its ancestors. Weight 0 marks the device out.
+func (m *Map) SetWeight(name string, weight float64) error {
+	b, ok := m.buckets[name]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if !b.IsDevice() {
+		return fmt.Errorf("crush: %s is not a device", name)
+	}
+	if weight < 0 || math.IsNaN(weight) {
+		return ErrInvalidWeight
+	}
+	delta := weight - b.Weight
+	for ; b != nil; b = b.parent {
+		b.Weight += delta
+	}
+	return nil
+}
+
+// straw2 returns the child of b with the highest draw for the key hashed to
+// khash on attempt r, or nil if every child has weight 0.
+func straw2(b *Bucket, khash uint64, r int) *Bucket {
+	var best *Bucket
+	var bestDraw float64
+	// Independent draws for the successive attempts.
+	khash = splitmix64.Mix(khash + uint64(r)*splitmix64.Gamma)
+	for _, c := range b.Children {
+		if c.Weight <= 0 {
+			continue
+		}
+		// u is in (0, 1], so the draw is in (-inf, 0].
+		u := float64(rendezvous.Score(khash, c.hash)>>11+1) / (1 << 53)
+		draw := math.Log(u) / c.Weight
+		if best == nil || draw > bestDraw {
+			best, bestDraw = c, draw
+		}
+	}
+	return best
+}
@@ -0,0 +1,242 @@
+package crush
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// getTopology returns dcs datacenters of racks racks of hosts hosts of
+// disks disks. The first disk of every host has weight 2, the others 1.
+func getTopology(dcs, racks, hosts, disks int) *Bucket {
+	root := &Bucket{Name: "root", Type: "root"}
+	for d := 0; d < dcs; d++ {
+		dc := &Bucket{Name: fmt.Sprintf("dc%d", d), Type: "datacenter"}
+		for r := 0; r < racks; r++ {
+			rack := &Bucket{Name: fmt.Sprintf("%s-rack%d", dc.Name, r), Type: "rack"}
+			for h := 0; h < hosts; h++ {
+				host := &Bucket{Name: fmt.Sprintf("%s-host%d", rack.Name, h), Type: "host"}
+				for k := 0; k < disks; k++ {
+					weight := 1.0
+					if k == 0 {
+						weight = 2
+					}
+					host.Children = append(host.Children, &Bucket{
+						Name:   fmt.Sprintf("%s-disk%d", host.Name, k),
+						Type:   "disk",
+						Weight: weight,
+					})
+				}
+				rack.Children = append(rack.Children, host)
+			}
+			dc.Children = append(dc.Children, rack)
+		}
+		root.Children = append(root.Children, dc)
+	}
+	return root
+}
+
+func mustParse(t *testing.T, s string) *Rule {
+	rule, err := ParseRule(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestParseRule(t *testing.T) {
+	rule := mustParse(t, `
+		# three replicas on different racks
+		take root
+		chooseleaf firstn 0 type rack
+		emit`)
+	expected := []Step{{Op: Take, Name: "root"}, {Op: ChooseLeaf, Type: "rack"}, {Op: Emit}}
+	if len(rule.Steps) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, rule.Steps)
+	}
+	for i, s := range rule.Steps {
+		if s != expected[i] {
+			t.Errorf("step %d: expected %v, got %v", i, expected[i], s)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"take root",
+		"take; emit",
+		"take root; choose 3 type rack; emit",
+		"take root; choose firstn x type rack; emit",
+		"take root; pick firstn 1 type rack; emit",
+		"take root; emit now",
+	} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestNewMapErrors(t *testing.T) {
+	root := getTopology(1, 1, 1, 2)
+	root.Children[0].Name = "root"
+	if _, err := NewMap(root, hashString); err != ErrDuplicateName {
+		t.Errorf("expected ErrDuplicateName, got %v", err)
+	}
+	root = getTopology(1, 1, 1, 2)
+	root.Children[0].Children[0].Children[0].Children[0].Weight = -1
+	if _, err := NewMap(root, hashString); err != ErrInvalidWeight {
+		t.Errorf("expected ErrInvalidWeight, got %v", err)
+	}
+	root = getTopology(1, 1, 1, 2)
+	m, _ := NewMap(root, hashString)
+	if err := m.SetWeight("unknown", 1); err != ErrBucketNotFound {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if err := m.SetWeight("dc0", 1); err == nil {
+		t.Error("expected not a device error")
+	}
+	if err := m.SetWeight("dc0-rack0-host0-disk0", -1); err != ErrInvalidWeight {
+		t.Errorf("expected ErrInvalidWeight, got %v", err)
+	}
+}
+
+func TestFailureDomains(t *testing.T) {
+	m, _ := NewMap(getTopology(2, 4, 3, 2), hashString)
+	rule := mustParse(t, "take root; chooseleaf firstn 0 type rack; emit")
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		devs, err := m.Place(rule, key, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devs) != 3 {
+			t.Fatalf("expected 3 devices for %s, got %v", key, devs)
+		}
+		racks := make(map[*Bucket]bool)
+		for _, d := range devs {
+			racks[m.Bucket(d).parent.parent] = true
+		}
+		if len(racks) != 3 {
+			t.Errorf("replicas of %s share a rack: %v", key, devs)
+		}
+		again, _ := m.Place(rule, key, 3)
+		for j := range devs {
+			if again[j] != devs[j] {
+				t.Fatalf("placement of %s is not deterministic: %v, %v", key, devs, again)
+			}
+		}
+	}
+}
+
+func TestNestedChoose(t *testing.T) {
+	m, _ := NewMap(getTopology(2, 4, 3, 2), hashString)
+	rule := mustParse(t, `
+		take root
+		choose firstn 2 type datacenter
+		chooseleaf firstn 2 type host
+		emit`)
+	for i := 0; i < 1000; i++ {
+		devs, _ := m.Place(rule, "key"+strconv.Itoa(i), 4)
+		if len(devs) != 4 {
+			t.Fatalf("expected 4 devices, got %v", devs)
+		}
+		hosts := make(map[*Bucket]bool)
+		dcs := make(map[*Bucket]int)
+		for _, d := range devs {
+			host := m.Bucket(d).parent
+			hosts[host] = true
+			dcs[host.parent.parent]++
+		}
+		if len(hosts) != 4 || len(dcs) != 2 {
+			t.Errorf("expected 2 hosts in each of 2 datacenters, got %v", devs)
+		}
+	}
+}
+
+func TestTooFewDomains(t *testing.T) {
+	m, _ := NewMap(getTopology(2, 2, 2, 2), hashString)
+	rule := mustParse(t, "take root; chooseleaf firstn 0 type datacenter; emit")
+	devs, _ := m.Place(rule, "key", 3)
+	if len(devs) != 2 {
+		t.Errorf("expected one device per datacenter, got %v", devs)
+	}
+	if _, err := m.Place(mustParse(t, "take nowhere; emit"), "key", 3); err != ErrBucketNotFound {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	root := getTopology(2, 4, 3, 2)
+	m, _ := NewMap(root, hashString)
+	rule := mustParse(t, "take root; chooseleaf firstn 0 type host; emit")
+	count := make(map[string]int)
+	testCount := 100000
+	for i := 0; i < testCount; i++ {
+		devs, _ := m.Place(rule, "key"+strconv.Itoa(i), 1)
+		count[devs[0]]++
+	}
+	for name, b := range m.buckets {
+		if !b.IsDevice() {
+			continue
+		}
+		expected := float64(testCount) * b.Weight / root.Weight
+		if n := float64(count[name]); n < expected*0.9 || n > expected*1.1 {
+			t.Errorf("%s got %.0f keys, expected about %.0f", name, n, expected)
+		}
+	}
+}
+
+func TestMarkOut(t *testing.T) {
+	m, _ := NewMap(getTopology(2, 4, 3, 2), hashString)
+	rule := mustParse(t, "take root; chooseleaf firstn 0 type rack; emit")
+	testCount := 20000
+	before := make([][]string, testCount)
+	for i := range before {
+		before[i], _ = m.Place(rule, "key"+strconv.Itoa(i), 3)
+	}
+
+	out := "dc1-rack2-host1-disk0"
+	share := m.Bucket(out).Weight / m.root.Weight
+	m.SetWeight(out, 0)
+	moved := 0
+	for i := range before {
+		after, _ := m.Place(rule, "key"+strconv.Itoa(i), 3)
+		for _, d := range after {
+			if d == out {
+				t.Fatalf("key%d placed on out device", i)
+			}
+		}
+		if after[0] != before[i][0] {
+			moved++
+		}
+	}
+	// Besides the primaries on the out device, the lower weight of its host,
+	// rack and datacenter moves some of their other keys at each level.
+	if r := float64(moved) / float64(testCount); r > share*3 {
+		t.Errorf("%.2f%% of the primaries moved, the out device held %.2f%%", r*100, share*100)
+	}
+
+	m.SetWeight(out, 2)
+	for i := range before {
+		after, _ := m.Place(rule, "key"+strconv.Itoa(i), 3)
+		for j := range after {
+			if after[j] != before[i][j] {
+				t.Fatalf("key%d did not return to %v, got %v", i, before[i], after)
+			}
+		}
+	}
+}
+
+func BenchmarkPlace(b *testing.B) {
+	m, _ := NewMap(getTopology(2, 4, 3, 2), hashString)
+	rule, _ := ParseRule("take root; chooseleaf firstn 0 type rack; emit")
+	for i := 0; i < b.N; i++ {
+		m.Place(rule, "Lorem ipsum dolor sit amet", 3)
+	}
+}
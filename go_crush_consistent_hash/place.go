@@ -0,0 +1,116 @@
+package crush
+
+// Place runs rule for key and returns the names of the emitted buckets,
+// devices for a chooseleaf rule. A replica that cannot be placed within
+// m.Tries attempts, for example because there are fewer failure domains
+// than replicas, is left out, so the result may be shorter than asked for.
+func (m *Map) Place(rule *Rule, key string, replicas int) ([]string, error) {
+	khash := m.hash(key)
+	var res []string
+	var working []*Bucket
+	for _, step := range rule.Steps {
+		switch step.Op {
+		case Take:
+			b, ok := m.buckets[step.Name]
+			if !ok {
+				return nil, ErrBucketNotFound
+			}
+			working = []*Bucket{b}
+		case Choose, ChooseLeaf:
+			n := step.N
+			if n <= 0 {
+				n += replicas
+			}
+			var next []*Bucket
+			for _, b := range working {
+				next = append(next, m.choose(b, khash, n, step.Type, step.Op == ChooseLeaf)...)
+			}
+			working = next
+		case Emit:
+			for _, b := range working {
+				res = append(res, b.Name)
+			}
+			working = nil
+		}
+	}
+	return res, nil
+}
+
+// choose picks n distinct buckets of type typ below b. With leaf set it
+// returns one device below each of them instead. Replica rep is tried with
+// r = rep, rep+1, ... as long as it collides with an earlier replica or
+// only reaches devices that are out.
+func (m *Map) choose(b *Bucket, khash uint64, n int, typ string, leaf bool) []*Bucket {
+	var res, chosen []*Bucket
+	ftotal := 0
+	for rep := 0; rep < n; rep++ {
+		for try := 0; try < m.Tries; try++ {
+			r := rep + ftotal
+			item := descend(b, khash, r, typ)
+			ok := item != nil && !contains(chosen, item)
+			var dev *Bucket
+			if ok && leaf {
+				dev = m.leaf(item, khash, r)
+				ok = dev != nil
+			}
+			if !ok {
+				ftotal++
+				continue
+			}
+			chosen = append(chosen, item)
+			if leaf {
+				res = append(res, dev)
+			} else {
+				res = append(res, item)
+			}
+			break
+		}
+	}
+	return res
+}
+
+// leaf picks a device below b, retrying until it finds one with a positive
+// weight.
+func (m *Map) leaf(b *Bucket, khash uint64, r int) *Bucket {
+	if b.IsDevice() {
+		if b.Weight > 0 {
+			return b
+		}
+		return nil
+	}
+	for try := 0; try < m.Tries; try++ {
+		if dev := descend(b, khash, r+try, ""); dev != nil {
+			return dev
+		}
+	}
+	return nil
+}
+
+// descend walks down from b with straw2 until it reaches a bucket of type
+// typ, or a device if typ is empty. It returns nil if it gets stuck.
+func descend(b *Bucket, khash uint64, r int, typ string) *Bucket {
+	for {
+		if b.IsDevice() {
+			if typ != "" && b.Type != typ || b.Weight <= 0 {
+				return nil
+			}
+			return b
+		}
+		b = straw2(b, khash, r)
+		if b == nil {
+			return nil
+		}
+		if typ != "" && b.Type == typ {
+			return b
+		}
+	}
+}
+
+func contains(buckets []*Bucket, b *Bucket) bool {
+	for _, c := range buckets {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package crush
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Op is the operation of a rule step.
+type Op int
+
+// Rule step operations.
+const (
+	// Take puts a bucket in the working set.
+	Take Op = iota
+	// Choose replaces each bucket of the working set with N distinct
+	// buckets of a type found below it.
+	Choose
+	// ChooseLeaf is Choose followed by picking one device below each
+	// chosen bucket.
+	ChooseLeaf
+	// Emit appends the working set to the result and clears it.
+	Emit
+)
+
+// Step is a rule step.
+type Step struct {
+	Op Op
+	// Name is the bucket of a Take step.
+	Name string
+	// N is the number of buckets of a Choose or ChooseLeaf step. 0 means
+	// the number of replicas and a negative N that many less.
+	N int
+	// Type is the bucket type of a Choose or ChooseLeaf step.
+	Type string
+}
+
+// Rule is a list of placement steps.
+type Rule struct {
+	Steps []Step
+}
+
+// ParseRule parses a rule, one step per line or separated by ';':
+//
+//	take <bucket>
+//	choose firstn <n> type <type>
+//	chooseleaf firstn <n> type <type>
+//	emit
+//
+// Text after '#' is a comment.
+func ParseRule(s string) (*Rule, error) {
+	rule := &Rule{}
+	for lineno, line := range strings.Split(strings.Replace(s, ";", "\n", -1), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		step, err := parseStep(f)
+		if err != nil {
+			return nil, fmt.Errorf("crush: rule step %d: %v", lineno+1, err)
+		}
+		rule.Steps = append(rule.Steps, step)
+	}
+	if len(rule.Steps) == 0 || rule.Steps[len(rule.Steps)-1].Op != Emit {
+		return nil, fmt.Errorf("crush: rule must end with emit")
+	}
+	return rule, nil
+}
+
+func parseStep(f []string) (Step, error) {
+	switch f[0] {
+	case "take":
+		if len(f) != 2 {
+			return Step{}, fmt.Errorf("expected take <bucket>")
+		}
+		return Step{Op: Take, Name: f[1]}, nil
+	case "choose", "chooseleaf":
+		if len(f) != 5 || f[1] != "firstn" || f[3] != "type" {
+			return Step{}, fmt.Errorf("expected %s firstn <n> type <type>", f[0])
+		}
+		n, err := strconv.Atoi(f[2])
+		if err != nil {
+			return Step{}, fmt.Errorf("invalid count %q", f[2])
+		}
+		op := Choose
+		if f[0] == "chooseleaf" {
+			op = ChooseLeaf
+		}
+		return Step{Op: op, N: n, Type: f[4]}, nil
+	case "emit":
+		if len(f) != 1 {
+			return Step{}, fmt.Errorf("expected emit")
+		}
+		return Step{Op: Emit}, nil
+	}
+	return Step{}, fmt.Errorf("unknown step %q", f[0])
+}
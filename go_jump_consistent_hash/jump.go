@@ -7,13 +7,20 @@
 package jump
 
 import (
+	"errors"
 	"hash"
 	"hash/crc32"
 	"hash/crc64"
 	"hash/fnv"
 	"io"
+
+	siphash "github.com/shanyux/consistent_hash/go_siphash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
 )
 
+// ErrBucketNotFound is returned when marking a bucket outside [0, N()).
+var ErrBucketNotFound = errors.New("jump: bucket not found")
+
 // Hash takes a 64 bit key and the number of buckets. It outputs a bucket
 // number in the range [0, buckets).
 // If the number of buckets is less than or equal to 0 then one 1 is used.
@@ -33,6 +40,49 @@ func JumpHash(key uint64, buckets int32) int32 {
 	return int32(b)
 }
 
+// JumpHashN returns up to n distinct buckets in the range [0, buckets) for
+// key. The first bucket is JumpHash(key, buckets), the following ones are
+// found by rehashing key until a new bucket comes up.
+func JumpHashN(key uint64, buckets int32, n int) []int32 {
+	if buckets <= 0 {
+		buckets = 1
+	}
+	return jumpHashN(key, buckets, n, nil)
+}
+
+// jumpHashN is JumpHashN leaving out the buckets in skip. n must not be
+// larger than the number of buckets not in skip.
+func jumpHashN(key uint64, buckets int32, n int, skip map[int32]bool) []int32 {
+	if n > int(buckets) {
+		n = int(buckets)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	res := make([]int32, 0, n)
+	seen := make(map[int32]bool, n)
+	b := JumpHash(key, buckets)
+	for tries := 0; ; tries++ {
+		if !seen[b] && !skip[b] {
+			seen[b] = true
+			res = append(res, b)
+			if len(res) == n {
+				return res
+			}
+		}
+		if tries < 4*int(buckets) {
+			// splitmix64 rather than the generator of JumpHash, which
+			// would just replay the same jumps.
+			key = splitmix64.Mix(key + splitmix64.Gamma)
+			b = JumpHash(key, buckets)
+		} else {
+			// Too many collisions, take the next buckets in order.
+			b = (b + 1) % buckets
+		}
+	}
+}
+
 // HashString takes string as key instead of an int and uses a KeyHasher to
 // generate a key compatible with Hash().
 func HashString(key string, buckets int32, h KeyHasher) int32 {
@@ -60,13 +110,48 @@ type KeyHasher interface {
 
 // Hasher represents a jump consistent hasher using a string as key.
 type Hasher struct {
-	n int32
-	h KeyHasher
+	n       int32
+	h       KeyHasher
+	extract func(key string) string
+	seed    uint64
+	down    map[int32]bool
+}
+
+// Option configures a Hasher.
+type Option func(h *Hasher)
+
+// WithKeyExtractor makes the hasher hash extract(key) instead of key, so
+// keys sharing a hash tag land in the same bucket.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(h *Hasher) {
+		h.extract = extract
+	}
+}
+
+// WithSeed salts the key hashes with seed, so hashers with different seeds
+// place keys independently of each other. Seed 0 is the unsalted hasher.
+func WithSeed(seed uint64) Option {
+	return func(h *Hasher) {
+		h.seed = seed
+	}
+}
+
+// WithSipHashKey replaces the KeyHasher with SipHash-2-4 under the secret
+// key, so clients that do not know the key cannot craft keys that all land
+// in the same bucket.
+func WithSipHashKey(key [siphash.KeySize]byte) Option {
+	return func(h *Hasher) {
+		h.h = siphash.New(key)
+	}
 }
 
 // New returns a new instance of of Hasher.
-func New(n int, h KeyHasher) *Hasher {
-	return &Hasher{int32(n), h}
+func New(n int, h KeyHasher, opts ...Option) *Hasher {
+	hasher := &Hasher{n: int32(n), h: h}
+	for _, opt := range opts {
+		opt(hasher)
+	}
+	return hasher
 }
 
 // N returns the number of buckets the hasher can assign to.
@@ -75,8 +160,81 @@ func (h *Hasher) N() int {
 }
 
 // Hash returns the integer hash for the given key.
+// Returns -1 if all buckets are down.
 func (h *Hasher) Hash(key string) int {
-	return int(HashString(key, h.n, h.h))
+	if len(h.down) == 0 {
+		return int(JumpHash(h.sum(key), h.n))
+	}
+	if b := h.HashN(key, 1); len(b) > 0 {
+		return b[0]
+	}
+	return -1
+}
+
+// sum returns the salted hash of key.
+func (h *Hasher) sum(key string) uint64 {
+	h.h.Reset()
+	_, err := io.WriteString(h.h, h.key(key))
+	if err != nil {
+		panic(err)
+	}
+	return splitmix64.Seed(h.h.Sum64(), h.seed)
+}
+
+func (h *Hasher) key(key string) string {
+	if h.extract != nil {
+		return h.extract(key)
+	}
+	return key
+}
+
+// HashN returns up to n distinct buckets for the given key, the first one
+// being Hash(key). Down buckets are skipped.
+func (h *Hasher) HashN(key string, n int) []int {
+	if len(h.down) == 0 {
+		return toInts(JumpHashN(h.sum(key), h.n, n))
+	}
+	if n > int(h.n)-len(h.down) {
+		n = int(h.n) - len(h.down)
+	}
+	return toInts(jumpHashN(h.sum(key), h.n, n, h.down))
+}
+
+// MarkDown marks bucket b as down. Hash skips it and rehashes keys to the
+// next bucket of their HashN chain instead, but unlike shrinking the hasher
+// the keys of the other buckets do not move and its own keys come back once
+// it is marked up.
+func (h *Hasher) MarkDown(b int) error {
+	if b < 0 || b >= int(h.n) {
+		return ErrBucketNotFound
+	}
+	if h.down == nil {
+		h.down = make(map[int32]bool)
+	}
+	h.down[int32(b)] = true
+	return nil
+}
+
+// MarkUp marks bucket b as up again.
+func (h *Hasher) MarkUp(b int) error {
+	if b < 0 || b >= int(h.n) {
+		return ErrBucketNotFound
+	}
+	delete(h.down, int32(b))
+	return nil
+}
+
+// IsDown reports whether bucket b is marked down.
+func (h *Hasher) IsDown(b int) bool {
+	return h.down[int32(b)]
+}
+
+func toInts(buckets []int32) []int {
+	res := make([]int, len(buckets))
+	for i, b := range buckets {
+		res[i] = int(b)
+	}
+	return res
 }
 
 // KeyHashers available in the standard library for use with HashString() and Hasher.
@@ -35,6 +35,24 @@ func TestJumpHash(t *testing.T) {
 	}
 }
 
+func TestJumpHashN(t *testing.T) {
+	for key := uint64(0); key < 1000; key++ {
+		bs := JumpHashN(key, 10, 3)
+		if len(bs) != 3 || bs[0] != JumpHash(key, 10) {
+			t.Fatalf("JumpHashN(%d) = %v, JumpHash = %d", key, bs, JumpHash(key, 10))
+		}
+		if bs[0] == bs[1] || bs[1] == bs[2] || bs[0] == bs[2] {
+			t.Errorf("duplicate buckets %v", bs)
+		}
+		if all := JumpHashN(key, 10, 20); len(all) != 10 {
+			t.Errorf("expected 10 buckets, got %v", all)
+		}
+	}
+	if bs := JumpHashN(1, 10, 0); bs != nil {
+		t.Errorf("expected no buckets, got %v", bs)
+	}
+}
+
 var jumpStringTestVectors = []struct {
 	key      string
 	buckets  int32
@@ -69,6 +87,117 @@ func TestHasher(t *testing.T) {
 	}
 }
 
+func TestHasherKeyExtractor(t *testing.T) {
+	hasher := New(10, NewFNV1a(), WithKeyExtractor(func(key string) string { return key[:4] }))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i%100)
+		if h := hasher.Hash(key + "profile"); h != hasher.Hash(key+"cart") || h != hasher.HashN(key, 2)[0] {
+			t.Errorf("keys with prefix %s are in different buckets", key)
+		}
+	}
+}
+
+func TestHasherSeed(t *testing.T) {
+	for _, v := range jumpStringTestVectors {
+		hasher := New(int(v.buckets), v.hasher(), WithSeed(0))
+		if h := hasher.Hash(v.key); int32(h) != v.expected {
+			t.Errorf("expected bucket for key=%s to be %d, got %d",
+				strconv.Quote(v.key), v.expected, h)
+		}
+	}
+
+	// Differently seeded hashers agree on about 1/buckets of the keys.
+	h1 := New(10, NewFNV1a(), WithSeed(1))
+	h2 := New(10, NewFNV1a(), WithSeed(2))
+	same := 0
+	testCount := 20000
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if h1.Hash(key) == h2.Hash(key) {
+			same++
+		}
+	}
+	if r := float64(same) / float64(testCount); r < 0.07 || r > 0.13 {
+		t.Errorf("seeded hashers agree on %.3f of the keys, expected about 0.1", r)
+	}
+}
+
+func TestHasherSipHashKey(t *testing.T) {
+	plain := New(10, NewFNV1a())
+	keyed := New(10, NewFNV1a(), WithSipHashKey([16]byte{1}))
+	again := New(10, nil, WithSipHashKey([16]byte{1}))
+	other := New(10, NewFNV1a(), WithSipHashKey([16]byte{2}))
+	unkeyed, same := 0, 0
+	testCount := 20000
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if keyed.Hash(key) != again.Hash(key) {
+			t.Fatalf("hashers with the same key disagree on %s", key)
+		}
+		if plain.Hash(key) == keyed.Hash(key) {
+			unkeyed++
+		}
+		if other.Hash(key) == keyed.Hash(key) {
+			same++
+		}
+	}
+	for _, n := range []int{unkeyed, same} {
+		if r := float64(n) / float64(testCount); r < 0.07 || r > 0.13 {
+			t.Errorf("keyed hashers agree on %.3f of the keys, expected about 0.1", r)
+		}
+	}
+}
+
+func TestHasherMarkDown(t *testing.T) {
+	h := New(10, NewFNV1a())
+	testCount := 10000
+	before := make([]int, testCount)
+	for i := range before {
+		before[i] = h.Hash(strconv.Itoa(i))
+	}
+	if err := h.MarkDown(3); err != nil {
+		t.Fatal(err)
+	}
+	if h.MarkDown(10) != ErrBucketNotFound || h.MarkUp(-1) != ErrBucketNotFound {
+		t.Error("expected ErrBucketNotFound for buckets outside [0, 10)")
+	}
+	if !h.IsDown(3) || h.IsDown(10) {
+		t.Fatal("expected only bucket 3 to be down")
+	}
+	for i := range before {
+		key := strconv.Itoa(i)
+		b := h.Hash(key)
+		if before[i] == 3 {
+			// The keys of the down bucket go to the next bucket of their
+			// HashN chain.
+			h.MarkUp(3)
+			next := h.HashN(key, 2)[1]
+			h.MarkDown(3)
+			if b != next {
+				t.Fatalf("expected %s in bucket %d, got %d", key, next, b)
+			}
+		} else if b != before[i] {
+			t.Fatalf("key %s of a healthy bucket moved from %d to %d", key, before[i], b)
+		}
+		if bs := h.HashN(key, 10); len(bs) != 9 || bs[0] != b {
+			t.Fatalf("expected the 9 up buckets starting with %d, got %v", b, bs)
+		}
+	}
+	h.MarkUp(3)
+	for i := range before {
+		if b := h.Hash(strconv.Itoa(i)); b != before[i] {
+			t.Fatalf("expected %d back in bucket %d, got %d", i, before[i], b)
+		}
+	}
+
+	for b := 0; b < 10; b++ {
+		h.MarkDown(b)
+	}
+	if h.Hash("key") != -1 || len(h.HashN("key", 3)) != 0 {
+		t.Error("expected no bucket when all buckets are down")
+	}
+}
+
 func ExampleHash() {
 	fmt.Print(JumpHash(256, 1024))
 	// Output: 520
@@ -0,0 +1,209 @@
+// Package adaptive adjusts the weights of a ketama.Ring to the load its
+// nodes report, so that nodes with more capacity get more keys. Each step
+// moves the weights part of the way towards the target and never moves
+// more than a budgeted fraction of the keys, which keeps caches warm and
+// the controller stable under noisy samples. Weights change through the
+// number of points of the nodes, Ring.SetPoints, so that even nodes of
+// weight 1 can move a little.
+package adaptive
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+// Sample is the load a node reported over the last period.
+type Sample struct {
+	Latency time.Duration
+	QPS     float64
+	Bytes   float64
+}
+
+// Config configures a Controller. The zero value is ready to use.
+type Config struct {
+	// Latency, QPS and Bytes weigh the metrics in the load of a node.
+	// All zero means latency only.
+	Latency, QPS, Bytes float64
+	// Damping, in (0, 1], is the fraction of the way to the target
+	// weights a step goes. Defaults to 0.5.
+	Damping float64
+	// MaxChurn is the largest fraction of the keys a step may move.
+	// Defaults to 0.05.
+	MaxChurn float64
+	// MinWeight and MaxWeight bound the weights, each weight standing for
+	// Ring.PointsPerWeight points. 0 means unbounded, but a node keeps at
+	// least one point. A node outside the bounds, for example after they
+	// changed, is brought within them over steps that respect MaxChurn.
+	MinWeight, MaxWeight uint
+}
+
+// Controller computes new weights from load samples and applies them to
+// a ring as numbers of points. It is not safe for concurrent use, nor is the ring.
+type Controller struct {
+	ring *ketama.Ring
+	cfg  Config
+}
+
+// NewController returns a Controller for ring.
+func NewController(ring *ketama.Ring, cfg Config) *Controller {
+	if cfg.Latency == 0 && cfg.QPS == 0 && cfg.Bytes == 0 {
+		cfg.Latency = 1
+	}
+	if cfg.Damping <= 0 || cfg.Damping > 1 {
+		cfg.Damping = 0.5
+	}
+	if cfg.MaxChurn <= 0 {
+		cfg.MaxChurn = 0.05
+	}
+	return &Controller{ring: ring, cfg: cfg}
+}
+
+// Step is the outcome of Controller.Step.
+type Step struct {
+	// Points holds the new number of virtual nodes of every node. The
+	// controller moves points rather than whole weights, so that small
+	// weights can change by less than one.
+	Points map[string]int
+	// Churn is the fraction of the keys that moved, see Ring.Movement.
+	Churn float64
+}
+
+// Step computes new weights from samples and applies them to the ring as
+// numbers of points. The load of a node is the weighted average of its
+// metrics, each divided by its mean over the sampled nodes; the target
+// weight of a node is its weight divided by its load, so that loads even
+// out. Nodes without a sample keep their points. The step is scaled down
+// until the rounded points move at most MaxChurn of the keys.
+func (c *Controller) Step(samples map[string]Sample) Step {
+	nodes := c.ring.Nodes()
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Key() < nodes[j].Key()
+	})
+	old := make(map[string]float64, len(nodes))
+	for _, n := range nodes {
+		old[n.Key()] = float64(c.ring.PointCount(n))
+	}
+
+	loads := c.loads(samples)
+	var sampledOld, sampledTarget float64
+	target := make(map[string]float64, len(loads))
+	for _, n := range nodes {
+		load, ok := loads[n.Key()]
+		if !ok {
+			continue
+		}
+		target[n.Key()] = old[n.Key()] / math.Max(load, 1e-3)
+		sampledOld += old[n.Key()]
+		sampledTarget += target[n.Key()]
+	}
+	// Keep the total points of the sampled nodes.
+	for node, t := range target {
+		if sampledTarget > 0 {
+			target[node] = t * sampledOld / sampledTarget
+		}
+	}
+
+	// Take the largest part of the damped step whose rounded points move
+	// at most MaxChurn of the keys.
+	next := c.round(old, target, c.cfg.Damping)
+	moved := c.ring.Movement(next)
+	if moved > c.cfg.MaxChurn {
+		lo, hi := 0.0, c.cfg.Damping
+		next = c.round(old, target, lo)
+		moved = c.ring.Movement(next)
+		for i := 0; i < 10; i++ {
+			mid := (lo + hi) / 2
+			p := c.round(old, target, mid)
+			if m := c.ring.Movement(p); m <= c.cfg.MaxChurn {
+				lo, next, moved = mid, p, m
+			} else {
+				hi = mid
+			}
+		}
+	}
+
+	res := Step{Points: next, Churn: moved}
+	for _, n := range nodes {
+		if p := next[n.Key()]; p != c.ring.PointCount(n) {
+			c.ring.SetPoints(n.Key(), p)
+		}
+	}
+	return res
+}
+
+// round returns the points of the nodes after going the fraction f of the
+// way from old to the clamped target, rounded. Nodes without a target keep
+// their points. Clamping the target rather than the result keeps f = 0 a
+// step that moves nothing, so the bounds never override MaxChurn.
+func (c *Controller) round(old, target map[string]float64, f float64) map[string]int {
+	res := make(map[string]int, len(old))
+	for node, p := range old {
+		res[node] = int(p)
+		if t, ok := target[node]; ok {
+			res[node] = int(math.Round(p + f*(c.clamp(t)-p)))
+		}
+	}
+	return res
+}
+
+// loads returns the load of each sampled node.
+func (c *Controller) loads(samples map[string]Sample) map[string]float64 {
+	metrics := []struct {
+		coef  float64
+		value func(s Sample) float64
+	}{
+		{c.cfg.Latency, func(s Sample) float64 { return float64(s.Latency) }},
+		{c.cfg.QPS, func(s Sample) float64 { return s.QPS }},
+		{c.cfg.Bytes, func(s Sample) float64 { return s.Bytes }},
+	}
+	names := make([]string, 0, len(samples))
+	for node := range samples {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+	res := make(map[string]float64, len(samples))
+	var coefs float64
+	for _, m := range metrics {
+		if m.coef == 0 || len(samples) == 0 {
+			continue
+		}
+		var mean float64
+		for _, node := range names {
+			mean += m.value(samples[node])
+		}
+		mean /= float64(len(samples))
+		if mean == 0 {
+			continue
+		}
+		coefs += m.coef
+		for node, s := range samples {
+			res[node] += m.coef * m.value(s) / mean
+		}
+	}
+	if coefs == 0 {
+		// No signal, every node is as loaded as the others.
+		for node := range samples {
+			res[node] = 1
+		}
+		return res
+	}
+	for node := range res {
+		res[node] /= coefs
+	}
+	return res
+}
+
+// clamp bounds the number of points p within the weight bounds.
+func (c *Controller) clamp(p float64) float64 {
+	perWeight := float64(c.ring.PointsPerWeight())
+	if min := math.Max(float64(c.cfg.MinWeight)*perWeight, 1); p < min {
+		return min
+	}
+	if max := float64(c.cfg.MaxWeight) * perWeight; c.cfg.MaxWeight > 0 && p > max {
+		return max
+	}
+	return p
+}
@@ -0,0 +1,219 @@
+package adaptive
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+// cluster simulates nodes of different capacities serving totalQPS spread
+// by the ring. The latency of a node grows with its utilization.
+type cluster struct {
+	ring       *ketama.Ring
+	capacities map[string]float64
+	keys       []string
+}
+
+const totalQPS = 10000
+
+func newCluster(capacities []float64, weight uint) *cluster {
+	c := &cluster{capacities: make(map[string]float64)}
+	var nodes []*ketama.Node
+	for i, capacity := range capacities {
+		label := fmt.Sprintf("127.0.0.1:800%d", i)
+		nodes = append(nodes, ketama.NewNode(label, nil, weight))
+		c.capacities[label] = capacity
+	}
+	c.ring = ketama.NewRing(nodes)
+	for i := 0; i < 20000; i++ {
+		c.keys = append(c.keys, strconv.Itoa(i))
+	}
+	return c
+}
+
+func (c *cluster) placement() map[string]string {
+	res := make(map[string]string, len(c.keys))
+	for _, k := range c.keys {
+		res[k] = c.ring.Get(k).Key()
+	}
+	return res
+}
+
+func (c *cluster) samples() map[string]Sample {
+	count := make(map[string]int)
+	for _, node := range c.placement() {
+		count[node]++
+	}
+	res := make(map[string]Sample)
+	for node, capacity := range c.capacities {
+		qps := totalQPS * float64(count[node]) / float64(len(c.keys))
+		utilization := qps / (capacity * totalQPS / c.totalCapacity())
+		res[node] = Sample{
+			Latency: 5*time.Millisecond + time.Duration(utilization*float64(10*time.Millisecond)),
+			QPS:     qps,
+			Bytes:   qps * 1024,
+		}
+	}
+	return res
+}
+
+func (c *cluster) totalCapacity() float64 {
+	var res float64
+	for _, capacity := range c.capacities {
+		res += capacity
+	}
+	return res
+}
+
+// imbalance returns the largest relative error between the share of the
+// keys of a node and its share of the capacity.
+func (c *cluster) imbalance() float64 {
+	count := make(map[string]int)
+	for _, node := range c.placement() {
+		count[node]++
+	}
+	var res float64
+	for node, capacity := range c.capacities {
+		share := float64(count[node]) / float64(len(c.keys))
+		res = math.Max(res, math.Abs(share/(capacity/c.totalCapacity())-1))
+	}
+	return res
+}
+
+func moved(before, after map[string]string) float64 {
+	n := 0
+	for k, node := range before {
+		if after[k] != node {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestConvergence(t *testing.T) {
+	c := newCluster([]float64{1, 1, 1, 2, 2, 0.5, 1, 1.5}, 20)
+	ctrl := NewController(c.ring, Config{MaxChurn: 0.03})
+	initial := c.imbalance()
+	for step := 0; step < 40; step++ {
+		before := c.placement()
+		st := ctrl.Step(c.samples())
+		m := moved(before, c.placement())
+		if st.Churn > 0.03 || math.Abs(m-st.Churn) > 0.005 {
+			t.Fatalf("step %d moved %f of the keys, expected churn %f, budget 0.03", step, m, st.Churn)
+		}
+		if step%10 == 9 {
+			fmt.Printf("step %d: imbalance %f, moved %f, points %v\n", step, c.imbalance(), m, st.Points)
+		}
+	}
+	if final := c.imbalance(); final > 0.15 || final > initial/3 {
+		t.Errorf("expected the load to follow the capacities, imbalance went from %f to %f", initial, final)
+	}
+
+	// Balanced loads leave the weights alone.
+	before := c.placement()
+	samples := c.samples()
+	for node, s := range samples {
+		s.Latency = 10 * time.Millisecond
+		samples[node] = s
+	}
+	ctrl.Step(samples)
+	if m := moved(before, c.placement()); m != 0 {
+		t.Errorf("equal latencies moved %f of the keys", m)
+	}
+}
+
+// TestSmallWeights checks that the small weights usually configured still
+// move towards the target, within the budget of every step.
+func TestSmallWeights(t *testing.T) {
+	for _, n := range []int{3, 4, 5, 6} {
+		for _, weight := range []uint{1, 2, 4, 5, 8} {
+			c := newCluster(make([]float64, n), weight)
+			ctrl := NewController(c.ring, Config{MaxChurn: 0.05})
+			slow := c.ring.Nodes()[0].Key()
+			samples := make(map[string]Sample)
+			for _, node := range c.ring.Nodes() {
+				samples[node.Key()] = Sample{Latency: time.Millisecond}
+			}
+			samples[slow] = Sample{Latency: 10 * time.Millisecond}
+			initial := c.ring.PointCount(c.ring.Nodes()[0])
+			for step := 0; step < 3; step++ {
+				before := c.placement()
+				st := ctrl.Step(samples)
+				if m := moved(before, c.placement()); st.Churn > 0.05 || math.Abs(m-st.Churn) > 0.005 {
+					t.Fatalf("%d nodes of weight %d: step %d moved %f of the keys, expected churn %f, budget 0.05",
+						n, weight, step, m, st.Churn)
+				}
+				if st.Churn == 0 {
+					t.Fatalf("%d nodes of weight %d: step %d did not move", n, weight, step)
+				}
+			}
+			if p := c.ring.PointCount(c.ring.Nodes()[0]); p >= initial {
+				t.Errorf("%d nodes of weight %d: the slow node went from %d to %d points", n, weight, initial, p)
+			}
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	c := newCluster([]float64{1, 1, 10}, 10)
+	ctrl := NewController(c.ring, Config{MinWeight: 5, MaxWeight: 15, MaxChurn: 1, Damping: 1})
+	perWeight := c.ring.PointsPerWeight()
+	for step := 0; step < 10; step++ {
+		st := ctrl.Step(c.samples())
+		for node, p := range st.Points {
+			if p < 5*perWeight || p > 15*perWeight {
+				t.Fatalf("%s got %d points outside [5, 15] weights", node, p)
+			}
+		}
+	}
+	// Nodes without samples keep their points.
+	st := ctrl.Step(map[string]Sample{})
+	for _, n := range c.ring.Nodes() {
+		if st.Points[n.Key()] != c.ring.PointCount(n) {
+			t.Errorf("%s changed points without samples", n.Key())
+		}
+	}
+	if st.Churn != 0 {
+		t.Errorf("expected no churn, got %f", st.Churn)
+	}
+}
+
+func TestBoundsChurn(t *testing.T) {
+	// Raising a node to MinWeight must not move more than MaxChurn of the
+	// keys at once.
+	c := newCluster([]float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 4)
+	c.ring.SetWeight("127.0.0.1:8000", 1)
+	ctrl := NewController(c.ring, Config{MinWeight: 4, MaxChurn: 0.02, Damping: 1})
+	min := 4 * c.ring.PointsPerWeight()
+	for step := 0; step < 20; step++ {
+		st := ctrl.Step(c.samples())
+		if st.Churn > 0.02 {
+			t.Fatalf("step %d moved %f of the keys", step, st.Churn)
+		}
+		if st.Points["127.0.0.1:8000"] >= min {
+			return
+		}
+	}
+	t.Errorf("expected the node to reach %d points", min)
+}
+
+func TestMetrics(t *testing.T) {
+	c := newCluster([]float64{1, 1}, 10)
+	ctrl := NewController(c.ring, Config{QPS: 1, MaxChurn: 1, Damping: 1})
+	label := c.ring.Nodes()[0].Key()
+	other := c.ring.Nodes()[1].Key()
+	st := ctrl.Step(map[string]Sample{
+		label: {Latency: time.Second, QPS: 300},
+		other: {Latency: time.Millisecond, QPS: 100},
+	})
+	// Only QPS counts: loads 1.5 and 0.5, targets 10/1.5 and 10/0.5
+	// scaled to a total of 20 weights.
+	perWeight := c.ring.PointsPerWeight()
+	if st.Points[label] != 5*perWeight || st.Points[other] != 15*perWeight {
+		t.Errorf("expected weights 5 and 15, got points %v", st.Points)
+	}
+}
@@ -0,0 +1,166 @@
+package dynamo
+
+import (
+	"errors"
+	"sync"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+var (
+	// ErrInvalidQuorum is returned by NewCoordinator unless
+	// 1 <= R, W <= N.
+	ErrInvalidQuorum = errors.New("dynamo: invalid quorum")
+	// ErrQuorum is returned when fewer than R or W replicas answered.
+	ErrQuorum = errors.New("dynamo: quorum not reached")
+)
+
+// Value is a versioned value. A higher version wins.
+type Value struct {
+	Data    []byte
+	Version uint64
+}
+
+// Transport sends requests to the nodes. It must be safe for concurrent
+// use.
+type Transport interface {
+	// Put stores value for key on node, unless node holds a higher
+	// version. hintFor is the owner node stores the key for, "" if node
+	// is an owner.
+	Put(node, key string, value Value, hintFor string) error
+	// Get returns the value of key on node and whether node has it.
+	Get(node, key string) (Value, bool, error)
+	// Delete drops key from node once it has been handed off.
+	Delete(node, key string) error
+}
+
+// Coordinator reads and writes keys on the preference list of the ring
+// with sloppy quorums: writes wait for W replicas, reads for R, and down
+// owners are replaced by the next healthy nodes, whose writes are recorded
+// as hints and handed off when the owner is back.
+// The ring must not be modified concurrently with the Coordinator.
+type Coordinator struct {
+	ring      *ketama.Ring
+	transport Transport
+	n, r, w   int
+	hints     *Hints
+}
+
+// NewCoordinator returns a Coordinator storing keys on n replicas with
+// read quorum r and write quorum w.
+func NewCoordinator(ring *ketama.Ring, transport Transport, n, r, w int) (*Coordinator, error) {
+	if r < 1 || w < 1 || r > n || w > n {
+		return nil, ErrInvalidQuorum
+	}
+	return &Coordinator{ring: ring, transport: transport, n: n, r: r, w: w, hints: NewHints()}, nil
+}
+
+// Hints returns the hints recorded by the coordinator.
+func (c *Coordinator) Hints() *Hints {
+	return c.hints
+}
+
+// Put writes value to the replicas of key and returns ErrQuorum if fewer
+// than W of them acknowledged it. Hints are recorded for the acknowledged
+// writes to stand-in nodes.
+func (c *Coordinator) Put(key string, value Value) error {
+	replicas := PreferenceList(c.ring, key, c.n)
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, rep := range replicas {
+		wg.Add(1)
+		go func(i int, rep Replica) {
+			defer wg.Done()
+			errs[i] = c.transport.Put(rep.Node, key, value, rep.HintFor)
+		}(i, rep)
+	}
+	wg.Wait()
+
+	acks := 0
+	for i, rep := range replicas {
+		if errs[i] != nil {
+			continue
+		}
+		acks++
+		if rep.HintFor != "" {
+			c.hints.Record(Hint{Owner: rep.HintFor, Holder: rep.Node, Key: key})
+		}
+	}
+	if acks < c.w {
+		return ErrQuorum
+	}
+	return nil
+}
+
+// Get reads key from the replicas and returns the highest version among
+// them, and whether any replica has the key. It returns ErrQuorum if fewer
+// than R replicas answered.
+func (c *Coordinator) Get(key string) (Value, bool, error) {
+	replicas := PreferenceList(c.ring, key, c.n)
+	type answer struct {
+		value Value
+		ok    bool
+		err   error
+	}
+	answers := make([]answer, len(replicas))
+	var wg sync.WaitGroup
+	for i, rep := range replicas {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			v, ok, err := c.transport.Get(node, key)
+			answers[i] = answer{v, ok, err}
+		}(i, rep.Node)
+	}
+	wg.Wait()
+
+	var res Value
+	found := false
+	acks := 0
+	for _, a := range answers {
+		if a.err != nil {
+			continue
+		}
+		acks++
+		if a.ok && (!found || a.value.Version > res.Version) {
+			res, found = a.value, true
+		}
+	}
+	if acks < c.r {
+		return Value{}, false, ErrQuorum
+	}
+	return res, found, nil
+}
+
+// Handoff replays the hints held for owner, which should have been marked
+// up on the ring: each key is copied from its stand-in node to owner and
+// dropped from the stand-in. Hints that fail stay recorded for the next
+// Handoff. It returns the number of keys handed off and the first error.
+func (c *Coordinator) Handoff(owner string) (int, error) {
+	var firstErr error
+	handed := 0
+	for _, h := range c.hints.For(owner) {
+		if err := c.handoff(h); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		c.hints.Remove(h)
+		handed++
+	}
+	return handed, firstErr
+}
+
+func (c *Coordinator) handoff(h Hint) error {
+	v, ok, err := c.transport.Get(h.Holder, h.Key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := c.transport.Put(h.Owner, h.Key, v, ""); err != nil {
+			return err
+		}
+	}
+	return c.transport.Delete(h.Holder, h.Key)
+}
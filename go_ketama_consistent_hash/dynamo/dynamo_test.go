@@ -0,0 +1,215 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// memTransport is an in-memory cluster.
+type memTransport struct {
+	mu     sync.Mutex
+	data   map[string]map[string]Value
+	hinted map[string]map[string]string
+	failed map[string]bool
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{
+		data:   make(map[string]map[string]Value),
+		hinted: make(map[string]map[string]string),
+		failed: make(map[string]bool),
+	}
+}
+
+func (m *memTransport) Put(node, key string, value Value, hintFor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed[node] {
+		return errUnreachable
+	}
+	if m.data[node] == nil {
+		m.data[node] = make(map[string]Value)
+		m.hinted[node] = make(map[string]string)
+	}
+	if old, ok := m.data[node][key]; ok && old.Version > value.Version {
+		return nil
+	}
+	m.data[node][key] = value
+	if hintFor != "" {
+		m.hinted[node][key] = hintFor
+	}
+	return nil
+}
+
+func (m *memTransport) Get(node, key string) (Value, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed[node] {
+		return Value{}, false, errUnreachable
+	}
+	v, ok := m.data[node][key]
+	return v, ok, nil
+}
+
+func (m *memTransport) Delete(node, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed[node] {
+		return errUnreachable
+	}
+	delete(m.data[node], key)
+	delete(m.hinted[node], key)
+	return nil
+}
+
+func newRing(n int) *ketama.Ring {
+	var nodes []*ketama.Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, ketama.NewNode(fmt.Sprintf("127.0.0.1:800%d", i), nil, 1))
+	}
+	return ketama.NewRing(nodes)
+}
+
+func TestPreferenceList(t *testing.T) {
+	ring := newRing(6)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		owners := ring.GetN(key, 3)
+		list := PreferenceList(ring, key, 3)
+		for j, rep := range list {
+			if rep.Node != owners[j].Key() || rep.HintFor != "" {
+				t.Fatalf("expected the owners %v, got %v", owners, list)
+			}
+		}
+	}
+
+	key := "key"
+	walk := ring.GetN(key, 6)
+	ring.MarkDown(walk[1].Key())
+	ring.MarkDown(walk[2].Key())
+	ring.MarkDown(walk[3].Key())
+	list := PreferenceList(ring, key, 3)
+	expected := []Replica{
+		{Node: walk[0].Key()},
+		{Node: walk[4].Key(), HintFor: walk[1].Key()},
+		{Node: walk[5].Key(), HintFor: walk[2].Key()},
+	}
+	if len(list) != 3 || list[0] != expected[0] || list[1] != expected[1] || list[2] != expected[2] {
+		t.Errorf("expected %v, got %v", expected, list)
+	}
+	ring.MarkDown(walk[0].Key())
+	if list := PreferenceList(ring, key, 3); len(list) != 2 {
+		t.Errorf("expected the 2 healthy nodes, got %v", list)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	ring := newRing(5)
+	transport := newMemTransport()
+	if _, err := NewCoordinator(ring, transport, 3, 4, 2); err != ErrInvalidQuorum {
+		t.Errorf("expected ErrInvalidQuorum, got %v", err)
+	}
+	c, _ := NewCoordinator(ring, transport, 3, 2, 2)
+	if err := c.Put("key", Value{Data: []byte("v1"), Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	owners := ring.GetN("key", 3)
+	for _, o := range owners {
+		if v := transport.data[o.Key()]["key"]; string(v.Data) != "v1" {
+			t.Errorf("expected v1 on %s, got %q", o.Key(), v.Data)
+		}
+	}
+
+	// A replica missing the newer version is outvoted.
+	transport.failed[owners[0].Key()] = true
+	if err := c.Put("key", Value{Data: []byte("v2"), Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	delete(transport.failed, owners[0].Key())
+	if v, ok, err := c.Get("key"); err != nil || !ok || string(v.Data) != "v2" {
+		t.Errorf("expected v2, got %q, %v, %v", v.Data, ok, err)
+	}
+	if _, ok, err := c.Get("missing"); ok || err != nil {
+		t.Errorf("expected a missing key, got %v, %v", ok, err)
+	}
+
+	// Unreachable owners that are not marked down break the quorum.
+	transport.failed[owners[1].Key()] = true
+	transport.failed[owners[2].Key()] = true
+	if err := c.Put("key", Value{Data: []byte("v3"), Version: 3}); err != ErrQuorum {
+		t.Errorf("expected ErrQuorum, got %v", err)
+	}
+	if _, _, err := c.Get("key"); err != ErrQuorum {
+		t.Errorf("expected ErrQuorum, got %v", err)
+	}
+}
+
+func TestHintedHandoff(t *testing.T) {
+	ring := newRing(5)
+	transport := newMemTransport()
+	c, _ := NewCoordinator(ring, transport, 3, 2, 2)
+	down := ring.Nodes()[2].Key()
+	ring.MarkDown(down)
+	transport.failed[down] = true
+
+	testCount := 1000
+	hinted := 0
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if err := c.Put(key, Value{Data: []byte(key), Version: 1}); err != nil {
+			t.Fatal(err)
+		}
+		for _, rep := range PreferenceList(ring, key, 3) {
+			if rep.HintFor != "" {
+				hinted++
+				if rep.HintFor != down || transport.hinted[rep.Node][key] != down {
+					t.Fatalf("expected %s to hold %s for %s", rep.Node, key, down)
+				}
+			}
+		}
+	}
+	fmt.Printf("%d of %d keys hinted for %s\n", hinted, testCount, down)
+	if hinted == 0 || c.Hints().Len() != hinted || len(c.Hints().For(down)) != hinted {
+		t.Fatalf("expected %d hints, got %d", hinted, c.Hints().Len())
+	}
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if v, ok, err := c.Get(key); err != nil || !ok || string(v.Data) != key {
+			t.Fatalf("expected to read %s while %s is down, got %q, %v, %v", key, down, v.Data, ok, err)
+		}
+	}
+
+	// A failed handoff keeps the hints.
+	ring.MarkUp(down)
+	if n, err := c.Handoff(down); n != 0 || err == nil || c.Hints().Len() != hinted {
+		t.Fatalf("expected the handoff to an unreachable node to fail, got %d, %v", n, err)
+	}
+	delete(transport.failed, down)
+	if n, err := c.Handoff(down); n != hinted || err != nil {
+		t.Fatalf("expected %d keys handed off, got %d, %v", hinted, n, err)
+	}
+	if c.Hints().Len() != 0 {
+		t.Errorf("expected no hint left, got %d", c.Hints().Len())
+	}
+	for node, keys := range transport.hinted {
+		if len(keys) != 0 {
+			t.Errorf("%s still holds %d hinted keys", node, len(keys))
+		}
+	}
+	// Every replica is back on its owners.
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		for _, o := range ring.GetN(key, 3) {
+			if v := transport.data[o.Key()][key]; string(v.Data) != key {
+				t.Fatalf("expected %s on %s, got %q", key, o.Key(), v.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package dynamo
+
+import (
+	"sort"
+	"sync"
+)
+
+// Hint records that Holder stores Key on behalf of the down node Owner.
+type Hint struct {
+	Owner  string
+	Holder string
+	Key    string
+}
+
+// Hints is the hinted handoff bookkeeping. It is safe for concurrent use.
+type Hints struct {
+	mu    sync.Mutex
+	hints map[string]map[Hint]bool
+}
+
+// NewHints returns empty Hints.
+func NewHints() *Hints {
+	return &Hints{hints: make(map[string]map[Hint]bool)}
+}
+
+// Record records hint. Recording a hint twice is a no-op.
+func (h *Hints) Record(hint Hint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.hints[hint.Owner]
+	if !ok {
+		m = make(map[Hint]bool)
+		h.hints[hint.Owner] = m
+	}
+	m[hint] = true
+}
+
+// Remove forgets hint.
+func (h *Hints) Remove(hint Hint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hints[hint.Owner], hint)
+	if len(h.hints[hint.Owner]) == 0 {
+		delete(h.hints, hint.Owner)
+	}
+}
+
+// For returns the hints for owner sorted by key and holder.
+func (h *Hints) For(owner string) []Hint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]Hint, 0, len(h.hints[owner]))
+	for hint := range h.hints[owner] {
+		res = append(res, hint)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Key != res[j].Key {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Holder < res[j].Holder
+	})
+	return res
+}
+
+// Len returns the number of recorded hints.
+func (h *Hints) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, m := range h.hints {
+		n += len(m)
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+// Package dynamo builds a small Dynamo[1] style replicated store on top of
+// a ketama.Ring: preference lists that skip down nodes, a quorum
+// coordinator talking to the nodes through a pluggable Transport, and
+// hinted handoff bookkeeping.
+//
+// [1] https://www.allthingsdistributed.com/files/amazon-dynamo-sosp2007.pdf
+package dynamo
+
+import (
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+// Replica is an entry of a preference list.
+type Replica struct {
+	// Node is the node to send the key to.
+	Node string
+	// HintFor is the down owner Node stands in for, "" if Node is one of
+	// the owners of the key.
+	HintFor string
+}
+
+// PreferenceList returns the n replicas of key. The owners of key are the
+// first n distinct nodes clockwise from it. Healthy owners hold the key
+// themselves; each down owner is replaced, in order, by the next healthy
+// node past them, which holds the key on its behalf until it recovers.
+// The list is shorter than n if there are not enough healthy nodes.
+func PreferenceList(ring *ketama.Ring, key string, n int) []Replica {
+	if n <= 0 {
+		return nil
+	}
+	var res []Replica
+	var downOwners []string
+	owners := 0
+	ring.Walk(key, func(node *ketama.Node, down bool) bool {
+		switch {
+		case owners < n:
+			owners++
+			if down {
+				downOwners = append(downOwners, node.Key())
+			} else {
+				res = append(res, Replica{Node: node.Key()})
+			}
+		case !down:
+			res = append(res, Replica{Node: node.Key(), HintFor: downOwners[0]})
+			downOwners = downOwners[1:]
+		}
+		return len(res) < n && (owners < n || len(downOwners) > 0)
+	})
+	return res
+}
@@ -0,0 +1,54 @@
+package ketama
+
+// MarkDown marks the node labeled NodeLable as down. Lookups skip its
+// points and use the next node clockwise instead, but unlike Remove the
+// node keeps its points, so keys of the other nodes do not move and its own
+// keys come back once it is marked up.
+func (r *Ring) MarkDown(NodeLable string) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	if r.down == nil {
+		r.down = make(map[*Node]bool)
+	}
+	r.down[r.nodes[i]] = true
+	return nil
+}
+
+// MarkUp marks the node labeled NodeLable as up again.
+func (r *Ring) MarkUp(NodeLable string) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	delete(r.down, r.nodes[i])
+	return nil
+}
+
+// IsDown reports whether the node labeled NodeLable is marked down.
+func (r *Ring) IsDown(NodeLable string) bool {
+	i := r.index(NodeLable)
+	return i >= 0 && r.down[r.nodes[i]]
+}
+
+// Walk calls fn with the distinct nodes of the ring in clockwise order from
+// the position of key, down nodes included, until fn returns false or all
+// nodes have been visited. down tells whether the node is marked down.
+func (r *Ring) Walk(key string, fn func(node *Node, down bool) bool) {
+	if len(r.virtualNodes) == 0 {
+		return
+	}
+	seen := make(map[*Node]bool)
+	start := r.search(r.keyHash(key))
+	for i := 0; i < len(r.virtualNodes) && len(seen) < len(r.nodes); i++ {
+		node := r.virtualNodes[(start+i)%len(r.virtualNodes)].node
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if !fn(node, r.down[node]) {
+			return
+		}
+	}
+}
@@ -5,21 +5,58 @@ package ketama
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
+
+	siphash "github.com/shanyux/consistent_hash/go_siphash"
 )
 
-// Node is the hashing ring node.
+// Node is the hashing ring node. NodeLable is its stable identity, the ring
+// points are hashed from it. The address and data can change without moving
+// any key.
 type Node struct {
 	NodeLable string
+	addr      string
 	data      interface{}
 	weight    uint
 	hash      uint32
+	zone      string
+	tokens    []uint32
+	// points overrides the number of virtual nodes derived from weight
+	// if pinned, see Ring.SetPoints.
+	points int
+	pinned bool
+}
+
+// NewNode creates a new Node, configured by opts.
+func NewNode(NodeLable string, data interface{}, weight uint, opts ...NodeOption) *Node {
+	n := &Node{NodeLable: NodeLable, data: data, weight: weight}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
 }
 
-// NewNode creates a new Node.
-func NewNode(NodeLable string, data interface{}, weight uint) *Node {
-	return &Node{NodeLable: NodeLable, data: data, weight: weight}
+// NodeOption configures a Node.
+type NodeOption func(n *Node)
+
+// WithAddr sets the network address of the node. Its points are hashed from
+// its label, a stable id, so the host behind it can be replaced with
+// Ring.SetAddr without moving any key.
+func WithAddr(addr string) NodeOption {
+	return func(n *Node) {
+		n.addr = addr
+	}
+}
+
+// WithZone puts the node in zone, the failure domain (availability zone,
+// rack...) GetNZones spreads replicas over.
+func WithZone(zone string) NodeOption {
+	return func(n *Node) {
+		n.zone = zone
+	}
 }
 
 // Key returns the Node NodeLable.
@@ -27,11 +64,24 @@ func (n *Node) Key() string {
 	return n.NodeLable
 }
 
+// Addr returns the Node address, NodeLable if it has none.
+func (n *Node) Addr() string {
+	if n.addr == "" {
+		return n.NodeLable
+	}
+	return n.addr
+}
+
 // Data returns the Node data.
 func (n *Node) Data() interface{} {
 	return n.data
 }
 
+// Zone returns the Node zone, "" if it has none.
+func (n *Node) Zone() string {
+	return n.zone
+}
+
 // Weight returns the Node weight.
 func (n *Node) Weight() uint {
 	return n.weight
@@ -45,82 +95,405 @@ func (s ByHash) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s ByHash) Less(i, j int) bool { return s[i].hash < s[j].hash }
 
 // Ring is the ketama hashing ring.
+// It is not safe for concurrent use.
 type Ring struct {
-	virtualNodes []*Node
+	nodes        []*Node
+	virtualNodes []point
+	extract      func(key string) string
+	seed         uint64
+	sipKey       *[siphash.KeySize]byte
+	down         map[*Node]bool
+	perWeight    int
 }
 
+// Option configures a Ring.
+type Option func(r *Ring)
+
+// WithKeyExtractor makes the ring hash extract(key) instead of key, so keys
+// sharing a hash tag land on the same node.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(r *Ring) {
+		r.extract = extract
+	}
+}
+
+// point is a virtual node on the ring.
+type point struct {
+	hash uint32
+	node *Node
+}
+
+var (
+	// ErrNodeExists is returned when adding a node whose label is already on the ring.
+	ErrNodeExists = errors.New("ketama: node already exists")
+	// ErrNodeNotFound is returned when removing a node that is not on the ring.
+	ErrNodeNotFound = errors.New("ketama: node not found")
+)
+
 // alignHash returns hash value with aligment.
 func alignHash(NodeLable string, align int) uint32 {
 	b := md5.Sum([]byte(NodeLable)) //16字节,4个字节一组
+	return alignDigest(b, align)
+}
+
+// alignDigest returns the align-th little endian uint32 of a md5 digest.
+func alignDigest(b [md5.Size]byte, align int) uint32 {
 	return ((uint32(b[3+align*4]&0xff) << 24) |
 		(uint32(b[2+align*4]&0xff) << 16) |
 		(uint32(b[1+align*4]&0xff) << 8) |
 		(uint32(b[0+align*4] & 0xff)))
 }
 
+// DefaultPointsPerWeight is the number of virtual nodes of each weight,
+// 40 md5 digests of 4 points.
+const DefaultPointsPerWeight = 40 * 4
+
+// nodePoints returns the virtual nodes of node, pointsPerWeight for each
+// weight, or one for each of its explicit tokens. The points of a weight
+// are a prefix of those of any larger weight.
+func (r *Ring) nodePoints(node *Node) []point {
+	if node.tokens != nil {
+		points := make([]point, len(node.tokens))
+		for i, t := range node.tokens {
+			points[i] = point{hash: t, node: node}
+		}
+		return points
+	}
+	count := r.PointCount(node)
+	points := make([]point, 0, count)
+	for j := 0; len(points) < count; j++ {
+		b := r.digest(r.salt(fmt.Sprintf("%s-%d", node.NodeLable, j)))
+		for n := 0; n < 4 && len(points) < count; n++ {
+			points = append(points, point{hash: alignDigest(b, n), node: node})
+		}
+	}
+	return points
+}
+
+// sortPoints sorts virtual nodes by hash, breaking ties by label so the
+// order does not depend on how the ring was built.
+func sortPoints(points []point) {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].node.NodeLable < points[j].node.NodeLable
+	})
+}
+
+// WithPointsPerWeight sets the number of virtual nodes of each weight,
+// DefaultPointsPerWeight if n is not positive. More points even out the
+// ownership of the nodes at the cost of memory and build time, Tune finds
+// the fewest points for a target imbalance.
+func WithPointsPerWeight(n int) Option {
+	return func(r *Ring) {
+		r.perWeight = n
+	}
+}
+
+// points returns the number of virtual nodes of each weight.
+func (r *Ring) points() int {
+	if r.perWeight <= 0 {
+		return DefaultPointsPerWeight
+	}
+	return r.perWeight
+}
+
+// PointsPerWeight returns the number of virtual nodes of each weight.
+func (r *Ring) PointsPerWeight() int {
+	return r.points()
+}
+
+// PointCount returns the number of virtual nodes of node on the ring: one
+// for each explicit token, the number set by SetPoints, or PointsPerWeight
+// for each weight.
+func (r *Ring) PointCount(node *Node) int {
+	switch {
+	case node.tokens != nil:
+		return len(node.tokens)
+	case node.pinned:
+		return node.points
+	}
+	return int(node.weight) * r.points()
+}
+
+// WithSeed salts the hashing of nodes and keys with seed, so rings with
+// different seeds place keys independently of each other. Seed 0 is the
+// unsalted ring.
+func WithSeed(seed uint64) Option {
+	return func(r *Ring) {
+		r.seed = seed
+	}
+}
+
+// salt prefixes s with the seed of the ring.
+func (r *Ring) salt(s string) string {
+	if r.seed == 0 {
+		return s
+	}
+	return strconv.FormatUint(r.seed, 10) + "#" + s
+}
+
+// WithSipHashKey hashes nodes and keys with the 128 bit SipHash-2-4 under
+// the secret key instead of md5, so clients that do not know the key cannot
+// craft keys that all land on the same node.
+func WithSipHashKey(key [siphash.KeySize]byte) Option {
+	return func(r *Ring) {
+		r.sipKey = &key
+	}
+}
+
+// digest returns the md5 digest of s, or its keyed SipHash if the ring has
+// a key.
+func (r *Ring) digest(s string) [md5.Size]byte {
+	if r.sipKey == nil {
+		return md5.Sum([]byte(s))
+	}
+	return siphash.Sum128(r.sipKey, []byte(s))
+}
+
 // NewRing creates a new Ring.
-func NewRing(realsNodes []*Node) *Ring {
+func NewRing(realsNodes []*Node, opts ...Option) *Ring {
 	// Create ring and init its virtualNodes.
 	hashRing := &Ring{} //哈希环
+	for _, opt := range opts {
+		opt(hashRing)
+	}
 	length := 0
 	for i := 0; i < len(realsNodes); i++ { //物理节点
-		length += int(realsNodes[i].weight) * 4 * 40
+		length += hashRing.PointCount(realsNodes[i])
 	}
-	hashRing.virtualNodes = make([]*Node, length) //虚拟节点
+	hashRing.nodes = make([]*Node, 0, len(realsNodes))
+	hashRing.virtualNodes = make([]point, 0, length) //虚拟节点
 	// Init each ring node.
-	k := 0
-	for i := 0; i < len(realsNodes); i++ {
-		node := realsNodes[i]
-		for j := 0; j < int(node.weight)*40; j++ {
-			NodeLable := fmt.Sprintf("%s-%d", node.NodeLable, j)
-			for n := 0; n < 4; n++ {
-				hashRing.virtualNodes[k] = &Node{}
-				hashRing.virtualNodes[k].NodeLable = node.NodeLable
-				hashRing.virtualNodes[k].weight = node.weight
-				hashRing.virtualNodes[k].data = node.data
-				hashRing.virtualNodes[k].hash = alignHash(NodeLable, n)
-				k++
-			}
-		}
+	for _, node := range realsNodes {
+		hashRing.nodes = append(hashRing.nodes, node)
+		hashRing.virtualNodes = append(hashRing.virtualNodes, hashRing.nodePoints(node)...)
 	}
-	sort.Sort(ByHash(hashRing.virtualNodes))
+	sortPoints(hashRing.virtualNodes)
 	return hashRing
 }
 
+// Search returns the clockwise successor of hash among n points sorted by
+// hash, that is the index of the first point whose hash is greater than
+// hash, wrapping around to 0. hashAt returns the hash of the i-th point.
+// Search returns 0 when n is 0.
+func Search(n int, hashAt func(i int) uint32, hash uint32) int {
+	i := sort.Search(n, func(i int) bool {
+		return hashAt(i) > hash
+	})
+	if i == n { //哈希环跳圈
+		return 0
+	}
+	return i
+}
+
+// search returns the index of the virtual node following hash.
+// The ring must not be empty.
+func (r *Ring) search(hash uint32) int {
+	return Search(len(r.virtualNodes), r.hashAt, hash)
+}
+
+func (r *Ring) hashAt(i int) uint32 {
+	return r.virtualNodes[i].hash
+}
+
 // Get node by NodeLable from ring.
 // Returns nil if the ring is empty.
 func (r *Ring) Get(NodeLable string) *Node {
 	if len(r.virtualNodes) == 0 {
 		return nil
 	}
-	if len(r.virtualNodes) == 1 {
-		return r.virtualNodes[0]
+	start := r.search(r.keyHash(NodeLable))
+	if len(r.down) == 0 {
+		return r.virtualNodes[start].node
 	}
-	left := 0
-	right := len(r.virtualNodes)
-	hash := alignHash(NodeLable, 0)
-	for {
-		mid := (left + right) / 2
-		if mid == len(r.virtualNodes) {
-			return r.virtualNodes[0]
+	for i := 0; i < len(r.virtualNodes); i++ {
+		if node := r.virtualNodes[(start+i)%len(r.virtualNodes)].node; !r.down[node] {
+			return node
 		}
-		var p uint32
-		m := r.virtualNodes[mid].hash
-		if mid == 0 {
-			p = 0
-		} else {
-			p = r.virtualNodes[mid-1].hash
+	}
+	return nil
+}
+
+// keyHash returns the ring position of key.
+func (r *Ring) keyHash(key string) uint32 {
+	if r.extract != nil {
+		key = r.extract(key)
+	}
+	return alignDigest(r.digest(r.salt(key)), 0)
+}
+
+// GetN returns up to n distinct nodes for key, walking the ring clockwise
+// from the node Get returns.
+// Returns nil if the ring is empty.
+func (r *Ring) GetN(key string, n int) []*Node {
+	if n > len(r.nodes)-len(r.down) {
+		n = len(r.nodes) - len(r.down)
+	}
+	if n <= 0 || len(r.virtualNodes) == 0 {
+		return nil
+	}
+	res := make([]*Node, 0, n)
+	seen := make(map[*Node]bool, n)
+	start := r.search(r.keyHash(key))
+	for i := 0; i < len(r.virtualNodes) && len(res) < n; i++ {
+		node := r.virtualNodes[(start+i)%len(r.virtualNodes)].node
+		if !seen[node] && !r.down[node] {
+			seen[node] = true
+			res = append(res, node)
 		}
-		if hash < m && hash > p { //查找到
-			return r.virtualNodes[mid]
+	}
+	return res
+}
+
+// Nodes returns the real nodes on the ring in insertion order.
+func (r *Ring) Nodes() []*Node {
+	res := make([]*Node, len(r.nodes))
+	copy(res, r.nodes)
+	return res
+}
+
+// Len returns the number of real nodes on the ring.
+func (r *Ring) Len() int {
+	return len(r.nodes)
+}
+
+// index returns the index of the node labeled NodeLable in r.nodes, or -1.
+func (r *Ring) index(NodeLable string) int {
+	for i, node := range r.nodes {
+		if node.NodeLable == NodeLable {
+			return i
 		}
-		if m < hash {
-			left = mid + 1
+	}
+	return -1
+}
+
+// Add adds node to the ring. Keys only move to the new node.
+// Adding a node with explicit tokens already on the ring returns a
+// *TokenCollisionError.
+func (r *Ring) Add(node *Node) error {
+	if r.index(node.NodeLable) >= 0 {
+		return ErrNodeExists
+	}
+	if err := r.checkTokens(node); err != nil {
+		return err
+	}
+	r.nodes = append(r.nodes, node)
+	r.virtualNodes = append(r.virtualNodes, r.nodePoints(node)...)
+	sortPoints(r.virtualNodes)
+	return nil
+}
+
+// SetAddr changes the address of the node labeled NodeLable in place. The
+// points of the node are untouched, so no key moves.
+func (r *Ring) SetAddr(NodeLable, addr string) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	r.nodes[i].addr = addr
+	return nil
+}
+
+// SetData changes the data of the node labeled NodeLable in place. No key
+// moves.
+func (r *Ring) SetData(NodeLable string, data interface{}) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	r.nodes[i].data = data
+	return nil
+}
+
+// SetWeight changes the weight of the node labeled NodeLable, dropping the
+// number of points set by SetPoints. The points of a weight are a prefix of
+// the points of any larger weight, so only keys moving to or from this node
+// move. Nodes with explicit tokens keep their points.
+func (r *Ring) SetWeight(NodeLable string, weight uint) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	node := r.nodes[i]
+	if node.weight == weight && !node.pinned {
+		return nil
+	}
+	count := r.PointCount(node)
+	node.weight = weight
+	node.pinned = false
+	r.resize(node, count)
+	return nil
+}
+
+// SetPoints sets the number of virtual nodes of the node labeled NodeLable
+// to n, regardless of its weight, so that its share of the keys can change
+// by less than a weight. Like with SetWeight, only keys moving to or from
+// this node move. The weight of the node is left as is, SetWeight drops
+// the number. Nodes with explicit tokens keep their points.
+func (r *Ring) SetPoints(NodeLable string, n int) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	if n < 0 {
+		n = 0
+	}
+	node := r.nodes[i]
+	count := r.PointCount(node)
+	node.points = n
+	node.pinned = true
+	r.resize(node, count)
+	return nil
+}
+
+// resize replaces the points of node, count of them, with its current
+// points.
+func (r *Ring) resize(node *Node, count int) {
+	if node.tokens != nil || r.PointCount(node) == count {
+		return
+	}
+	kept := make([]point, 0, len(r.virtualNodes))
+	for _, p := range r.virtualNodes {
+		if p.node != node {
+			kept = append(kept, p)
+		}
+	}
+	points := r.nodePoints(node)
+	sortPoints(points)
+	r.virtualNodes = mergePoints(kept, points)
+}
+
+// mergePoints merges a and b, both sorted as by sortPoints.
+func mergePoints(a, b []point) []point {
+	res := make([]point, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].hash < a[0].hash || b[0].hash == a[0].hash && b[0].node.NodeLable < a[0].node.NodeLable {
+			res, b = append(res, b[0]), b[1:]
 		} else {
-			right = mid - 1
+			res, a = append(res, a[0]), a[1:]
 		}
-		if left > right { //哈希环跳圈
-			return r.virtualNodes[0]
+	}
+	return append(append(res, a...), b...)
+}
+
+// Remove removes the node labeled NodeLable from the ring. Only the keys
+// of the removed node move.
+func (r *Ring) Remove(NodeLable string) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	node := r.nodes[i]
+	delete(r.down, node)
+	r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+	points := r.virtualNodes[:0]
+	for _, p := range r.virtualNodes {
+		if p.node != node {
+			points = append(points, p)
 		}
 	}
+	r.virtualNodes = points
+	return nil
 }
@@ -82,6 +82,551 @@ func TestConsistence(t *testing.T) {
 	}
 }
 
+func TestAddRemove(t *testing.T) {
+	nodes := []*Node{
+		NewNode("192.168.0.1:9527", nil, 1),
+		NewNode("192.168.0.2:9527", nil, 1),
+		NewNode("192.168.0.3:9527", nil, 2),
+	}
+	ring := NewRing(nodes)
+	Must(t, ring.Add(NewNode("192.168.0.3:9527", nil, 1)) == ErrNodeExists)
+	Must(t, ring.Remove("192.168.0.9:9527") == ErrNodeNotFound)
+
+	keys := make([]string, 1024)
+	before := make(map[string]string)
+	for i := range keys {
+		keys[i] = RandString(32)
+		before[keys[i]] = ring.Get(keys[i]).Key()
+	}
+	Must(t, ring.Add(NewNode("192.168.0.4:9527", nil, 1)) == nil)
+	Must(t, len(ring.virtualNodes) == (1+1+2+1)*160)
+	for _, key := range keys {
+		k := ring.Get(key).Key()
+		Must(t, k == before[key] || k == "192.168.0.4:9527")
+	}
+	Must(t, ring.Remove("192.168.0.4:9527") == nil)
+	Must(t, len(ring.Nodes()) == 3)
+	for _, key := range keys {
+		Must(t, ring.Get(key).Key() == before[key])
+	}
+	// Building the ring at once gives the same placement as adding nodes.
+	rebuilt := NewRing(nil)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		rebuilt.Add(nodes[i])
+	}
+	for _, key := range keys {
+		Must(t, rebuilt.Get(key).Key() == before[key])
+	}
+}
+
+func TestGetN(t *testing.T) {
+	nodes := []*Node{
+		NewNode("192.168.0.1:9527", nil, 1),
+		NewNode("192.168.0.2:9527", nil, 1),
+		NewNode("192.168.0.3:9527", nil, 1),
+		NewNode("192.168.0.4:9527", nil, 1),
+	}
+	ring := NewRing(nodes)
+	Must(t, ring.GetN("key", 2) != nil)
+	Must(t, NewRing(nil).GetN("key", 2) == nil)
+	for i := 0; i < 1024; i++ {
+		key := RandString(32)
+		ns := ring.GetN(key, 3)
+		Must(t, len(ns) == 3)
+		Must(t, ns[0] == ring.Get(key))
+		Must(t, ns[0] != ns[1] && ns[1] != ns[2] && ns[0] != ns[2])
+		Must(t, len(ring.GetN(key, 10)) == len(nodes))
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	nodes := []*Node{
+		NewNode("192.168.0.1:9527", nil, 1),
+		NewNode("192.168.0.2:9527", nil, 1),
+		NewNode("192.168.0.3:9527", nil, 1),
+	}
+	ring := NewRing(nodes, WithKeyExtractor(func(key string) string { return key[:4] }))
+	for i := 0; i < 1024; i++ {
+		key := RandString(32)
+		Must(t, ring.Get(key) == ring.Get(key[:4]+RandString(8)))
+		Must(t, ring.GetN(key, 2)[0] == ring.Get(key[:4]))
+	}
+}
+
+// seedTestVectors were computed before seeds existed.
+var seedTestVectors = []struct {
+	key  string
+	node string
+}{
+	{"key1", "127.0.0.1:8001"},
+	{"key2", "127.0.0.1:8004"},
+	{"key3", "127.0.0.1:8002"},
+	{"key4", "127.0.0.1:8003"},
+	{"hello", "127.0.0.1:8003"},
+	{"world", "127.0.0.1:8004"},
+}
+
+func TestSeed(t *testing.T) {
+	nodes := getServerNodes(5, 1)
+	for _, ring := range []*Ring{NewRing(nodes), NewRing(nodes, WithSeed(0))} {
+		for _, v := range seedTestVectors {
+			Must(t, ring.Get(v.key).Key() == v.node)
+		}
+	}
+
+	// Differently seeded rings agree on about 1/len(nodes) of the keys.
+	nodes = getServerNodes(10, 1)
+	ring1 := NewRing(nodes, WithSeed(1))
+	ring2 := NewRing(nodes, WithSeed(2))
+	same := 0
+	N := 20000
+	for i := 0; i < N; i++ {
+		key := strconv.Itoa(i)
+		if ring1.Get(key) == ring2.Get(key) {
+			same++
+		}
+	}
+	r := float64(same) / float64(N)
+	if r < 0.07 || r > 0.13 {
+		t.Errorf("seeded rings agree on %.3f of the keys, expected about 0.1", r)
+	}
+}
+
+func TestSipHashKey(t *testing.T) {
+	nodes := getServerNodes(10, 1)
+	plain := NewRing(nodes)
+	keyed := NewRing(nodes, WithSipHashKey([16]byte{1}))
+	again := NewRing(nodes, WithSipHashKey([16]byte{1}))
+	other := NewRing(nodes, WithSipHashKey([16]byte{2}))
+	unkeyed, same := 0, 0
+	N := 20000
+	for i := 0; i < N; i++ {
+		key := strconv.Itoa(i)
+		Must(t, keyed.Get(key) == again.Get(key))
+		if plain.Get(key) == keyed.Get(key) {
+			unkeyed++
+		}
+		if other.Get(key) == keyed.Get(key) {
+			same++
+		}
+	}
+	for _, n := range []int{unkeyed, same} {
+		if r := float64(n) / float64(N); r < 0.07 || r > 0.13 {
+			t.Errorf("keyed rings agree on %.3f of the keys, expected about 0.1", r)
+		}
+	}
+}
+
+func TestMarkDown(t *testing.T) {
+	nodes := getServerNodes(10, 1)
+	ring := NewRing(nodes)
+	N := 10000
+	before := make([]*Node, N)
+	for i := range before {
+		before[i] = ring.Get(strconv.Itoa(i))
+	}
+	down := nodes[3].Key()
+	Must(t, ring.MarkDown(down) == nil && ring.IsDown(down))
+	Must(t, ring.MarkDown("unknown") == ErrNodeNotFound)
+	for i := range before {
+		key := strconv.Itoa(i)
+		node := ring.Get(key)
+		if before[i].Key() == down {
+			// The keys of the down node go to the next node clockwise.
+			Must(t, node == ring.GetN(key, 1)[0] && node.Key() != down)
+		} else {
+			Must(t, node == before[i])
+		}
+		for _, n := range ring.GetN(key, 9) {
+			Must(t, n.Key() != down)
+		}
+		Must(t, len(ring.GetN(key, 10)) == 9)
+	}
+	Must(t, ring.MarkUp(down) == nil && !ring.IsDown(down))
+	for i := range before {
+		Must(t, ring.Get(strconv.Itoa(i)) == before[i])
+	}
+
+	for _, n := range nodes {
+		ring.MarkDown(n.Key())
+	}
+	Must(t, ring.Get("key") == nil && ring.GetN("key", 3) == nil)
+	ring.Remove(nodes[0].Key())
+	ring.Add(nodes[0])
+	Must(t, !ring.IsDown(nodes[0].Key()) && ring.Get("key") == nodes[0])
+}
+
+func TestWalk(t *testing.T) {
+	ring := NewRing(getServerNodes(10, 1))
+	ring.MarkDown(ring.Get("key").Key())
+	var walked []*Node
+	ring.Walk("key", func(node *Node, down bool) bool {
+		Must(t, down == (len(walked) == 0))
+		walked = append(walked, node)
+		return true
+	})
+	Must(t, len(walked) == 10 && ring.IsDown(walked[0].Key()))
+	// Without down nodes the walk is GetN.
+	Must(t, ring.MarkUp(walked[0].Key()) == nil)
+	for i, node := range ring.GetN("key", 10) {
+		Must(t, walked[i] == node)
+	}
+	count := 0
+	ring.Walk("key", func(node *Node, down bool) bool {
+		count++
+		return count < 3
+	})
+	Must(t, count == 3)
+	NewRing(nil).Walk("key", func(node *Node, down bool) bool {
+		t.Fatal("an empty ring has no node")
+		return true
+	})
+}
+
+func TestSetAddr(t *testing.T) {
+	var nodes []*Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, NewNode(fmt.Sprintf("node%d", i), i, 1, WithAddr(fmt.Sprintf("127.0.0.1:800%d", i))))
+	}
+	ring := NewRing(nodes)
+	N := 10000
+	before := make([]*Node, N)
+	for i := range before {
+		before[i] = ring.Get(strconv.Itoa(i))
+	}
+
+	Must(t, nodes[3].Addr() == "127.0.0.1:8003" && nodes[3].Key() == "node3")
+	Must(t, ring.SetAddr("node3", "10.0.0.1:8000") == nil)
+	Must(t, ring.SetData("node3", "replaced") == nil)
+	Must(t, ring.SetAddr("unknown", "10.0.0.1:8000") == ErrNodeNotFound)
+	Must(t, ring.SetData("unknown", nil) == ErrNodeNotFound)
+	moved := 0
+	for i := range before {
+		node := ring.Get(strconv.Itoa(i))
+		if node != before[i] {
+			moved++
+		}
+		if node.Key() == "node3" {
+			Must(t, node.Addr() == "10.0.0.1:8000" && node.Data() == "replaced")
+		}
+	}
+	Must(t, moved == 0)
+
+	// Nodes without an address are addressed by their label.
+	Must(t, NewNode("127.0.0.1:8000", nil, 1).Addr() == "127.0.0.1:8000")
+
+	// A stable id, an address, a zone and explicit tokens combine.
+	tokens := []uint32{1 << 30, 3 << 30}
+	combined := NewNode("node10", nil, 1, WithAddr("10.0.0.2:8000"), WithZone("b"), WithTokens(tokens))
+	Must(t, combined.Key() == "node10" && combined.Addr() == "10.0.0.2:8000" && combined.Zone() == "b")
+	Must(t, fmt.Sprint(combined.Tokens()) == fmt.Sprint(tokens))
+	Must(t, ring.Add(combined) == nil && ring.SetZone("node10", "c") == nil && combined.Zone() == "c")
+	Must(t, ring.SetZone("unknown", "c") == ErrNodeNotFound)
+	Must(t, len(ring.GetNZones("key", 11)) == 11)
+}
+
+func TestTokenRing(t *testing.T) {
+	a := NewNode("a", nil, 1, WithTokens([]uint32{0, 1 << 30, 2 << 30, 3 << 30}))
+	b := NewNode("b", nil, 1, WithTokens([]uint32{1 << 29, 3 << 29}))
+	ring, err := NewTokenRing([]*Node{a, b})
+	Must(t, err == nil)
+	Must(t, len(ring.virtualNodes) == 6 && b.Tokens()[1] == 3<<29 && NewNode("c", nil, 1).Tokens() == nil)
+	// A key goes to the first token greater than its hash.
+	for _, v := range []struct {
+		hash uint32
+		node *Node
+	}{{0, b}, {1<<29 - 1, b}, {1 << 29, a}, {3<<29 - 1, b}, {3<<30 + 1, a}} {
+		Must(t, ring.virtualNodes[ring.search(v.hash)].node == v.node)
+	}
+
+	_, err = NewTokenRing([]*Node{a, NewNode("c", nil, 1, WithTokens([]uint32{5, 2 << 30}))})
+	e, ok := err.(*TokenCollisionError)
+	Must(t, ok && e.Token == 2<<30 && e.Node == "c" && e.Other == "a")
+	Must(t, err.Error() == "ketama: token 2147483648 of node c collides with node a")
+	_, err = NewTokenRing([]*Node{NewNode("c", nil, 1, WithTokens([]uint32{5, 5}))})
+	e, ok = err.(*TokenCollisionError)
+	Must(t, ok && e.Token == 5 && e.Node == "c" && e.Other == "c")
+	_, err = NewTokenRing([]*Node{a, NewNode("a", nil, 1, WithTokens([]uint32{7}))})
+	Must(t, err == ErrNodeExists)
+
+	// A node with points derived from its label collides with a token
+	// placed on one of them.
+	derived := NewNode("d", nil, 1)
+	token := NewRing(nil).nodePoints(derived)[0].hash
+	_, err = NewTokenRing([]*Node{NewNode("c", nil, 1, WithTokens([]uint32{token})), derived})
+	e, ok = err.(*TokenCollisionError)
+	Must(t, ok && e.Token == token && e.Node == "c" && e.Other == "d")
+	Must(t, ring.Add(NewNode("c", nil, 1, WithTokens([]uint32{1 << 30}))) != nil && len(ring.Nodes()) == 2)
+}
+
+func TestAllocateTokens(t *testing.T) {
+	tokens := NewRing(nil).AllocateTokens(4, 1)
+	Must(t, len(tokens) == 4 && tokens[0] == 0 && tokens[1] == 1<<30 && tokens[3] == 3<<30)
+
+	nodes := getServerNodes(10, 1)
+	ring := NewRing(nodes)
+	maxAvg := func() (float64, map[*Node]uint64) {
+		byNode := make(map[*Node]uint64)
+		for i, o := range ownership(ring.virtualNodes) {
+			byNode[ring.virtualNodes[i].node] += o
+		}
+		var max uint64
+		for _, o := range byNode {
+			if o > max {
+				max = o
+			}
+		}
+		return float64(max) / (float64(ringSize) / float64(len(byNode))), byNode
+	}
+	before, _ := maxAvg()
+	tokens = ring.AllocateTokens(160, 1)
+	Must(t, len(tokens) == 160)
+	joining := NewNode("joining", nil, 1, WithTokens(tokens))
+	Must(t, ring.Add(joining) == nil)
+	after, byNode := maxAvg()
+	share := float64(byNode[joining]) / float64(ringSize)
+	fmt.Printf("max/avg ownership %f before, %f after, joining node owns %f\n", before, after, share)
+	if share < 0.99/11 || share > 1.01/11 {
+		t.Errorf("expected the joining node to own 1/11 of the ring, got %f", share)
+	}
+	Must(t, after <= before)
+
+	// A node of weight 2 gets twice the share.
+	ring = NewRing(getServerNodes(10, 1))
+	heavy := NewNode("heavy", nil, 2, WithTokens(ring.AllocateTokens(320, 2)))
+	Must(t, ring.Add(heavy) == nil)
+	_, byNode = maxAvg()
+	if share := float64(byNode[heavy]) / float64(ringSize); share < 1.98/12 || share > 2.02/12 {
+		t.Errorf("expected the heavy node to own 2/12 of the ring, got %f", share)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	a := NewNode("a", nil, 1, WithTokens([]uint32{0, 1 << 30, 2 << 30, 3 << 30}))
+	b := NewNode("b", nil, 1, WithTokens([]uint32{1 << 29, 3 << 29}))
+	ring, _ := NewTokenRing([]*Node{a, b})
+	ranges, err := ring.Ranges("a")
+	Must(t, err == nil && len(ranges) == 2)
+	Must(t, ranges[0] == Range{1 << 29, 1 << 30} && ranges[1] == Range{3 << 29, 1 << 32})
+	ranges, _ = ring.Ranges("b")
+	Must(t, len(ranges) == 2 && ranges[0] == Range{0, 1 << 29} && ranges[1] == Range{1 << 30, 3 << 29})
+	ranges, _ = ring.RangesN("b", 2)
+	Must(t, len(ranges) == 1 && ranges[0] == Range{0, 1 << 32})
+	_, err = ring.Ranges("c")
+	Must(t, err == ErrNodeNotFound)
+
+	single, _ := NewTokenRing([]*Node{NewNode("a", nil, 1, WithTokens([]uint32{42}))})
+	ranges, _ = single.Ranges("a")
+	Must(t, len(ranges) == 1 && ranges[0] == Range{0, 1 << 32})
+
+	nodes := getServerNodes(10, 1)
+	ring = NewRing(nodes)
+	for _, replicas := range []int{1, 3} {
+		// Every hash is covered exactly replicas times: sort all range
+		// boundaries and sweep.
+		type edge struct {
+			at    uint64
+			delta int
+		}
+		var edges []edge
+		var total uint64
+		for _, node := range nodes {
+			ranges, err := ring.RangesN(node.Key(), replicas)
+			Must(t, err == nil)
+			for i, rg := range ranges {
+				Must(t, rg.Start < rg.End && rg.End <= 1<<32)
+				Must(t, i == 0 || ranges[i-1].End < rg.Start)
+				edges = append(edges, edge{rg.Start, 1}, edge{rg.End, -1})
+				total += rg.Len()
+			}
+		}
+		Must(t, total == uint64(replicas)<<32)
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].at != edges[j].at {
+				return edges[i].at < edges[j].at
+			}
+			return edges[i].delta < edges[j].delta
+		})
+		depth := 0
+		for i, e := range edges {
+			depth += e.delta
+			if i+1 < len(edges) && edges[i+1].at > e.at {
+				Must(t, depth == replicas)
+			}
+		}
+	}
+
+	// The ranges agree with Get and GetN.
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(i)
+		h := ring.keyHash(key)
+		ranges, _ := ring.Ranges(ring.Get(key).Key())
+		found := false
+		for _, rg := range ranges {
+			found = found || rg.Contains(h)
+		}
+		Must(t, found)
+		for _, node := range ring.GetN(key, 3) {
+			ranges, _ := ring.RangesN(node.Key(), 3)
+			found := false
+			for _, rg := range ranges {
+				found = found || rg.Contains(h)
+			}
+			Must(t, found)
+		}
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	nodes := getServerNodes(10, 2)
+	ring := NewRing(nodes)
+	N := 20000
+	before := make([]*Node, N)
+	for i := range before {
+		before[i] = ring.Get(strconv.Itoa(i))
+	}
+	label := nodes[4].Key()
+	Must(t, ring.SetWeight(label, 4) == nil && nodes[4].Weight() == 4)
+	Must(t, ring.SetWeight("unknown", 4) == ErrNodeNotFound)
+	gained := 0
+	for i := range before {
+		node := ring.Get(strconv.Itoa(i))
+		if node != before[i] {
+			Must(t, node.Key() == label)
+			gained++
+		}
+	}
+	// The node goes from 2/20 to 4/22 of the keys.
+	if f := float64(gained) / float64(N); f < 0.06 || f > 0.1 {
+		t.Errorf("expected about 0.08 of the keys to move, got %f", f)
+	}
+
+	// Back to the original weight, back to the original placement, and
+	// the same ring as one built with that weight.
+	Must(t, ring.SetWeight(label, 2) == nil)
+	fresh := NewRing(getServerNodes(10, 2))
+	for i := range before {
+		key := strconv.Itoa(i)
+		Must(t, ring.Get(key) == before[i] && fresh.Get(key).Key() == before[i].Key())
+	}
+	Must(t, ring.SetWeight(label, 0) == nil)
+	for i := range before {
+		Must(t, ring.Get(strconv.Itoa(i)).Key() != label)
+	}
+
+	token, _ := NewTokenRing([]*Node{NewNode("a", nil, 1, WithTokens([]uint32{1, 2}))})
+	Must(t, token.SetWeight("a", 3) == nil && len(token.virtualNodes) == 2)
+	Must(t, token.SetPoints("a", 7) == nil && len(token.virtualNodes) == 2)
+}
+
+func TestSetPoints(t *testing.T) {
+	nodes := getServerNodes(10, 1)
+	ring := NewRing(nodes)
+	N := 20000
+	before := make([]*Node, N)
+	for i := range before {
+		before[i] = ring.Get(strconv.Itoa(i))
+	}
+	label := nodes[4].Key()
+	Must(t, ring.SetPoints("unknown", 4) == ErrNodeNotFound)
+
+	// A weight 1 node warms up by a tenth of its points at a time.
+	Must(t, ring.SetPoints(label, 0) == nil)
+	prev := make([]*Node, N)
+	for i := range prev {
+		prev[i] = ring.Get(strconv.Itoa(i))
+		Must(t, prev[i].Key() != label)
+	}
+	for k := 1; k <= 10; k++ {
+		movement := ring.Movement(map[string]int{label: k * ring.PointsPerWeight() / 10, "unknown": 1})
+		Must(t, ring.SetPoints(label, k*ring.PointsPerWeight()/10) == nil)
+		Must(t, ring.PointCount(nodes[4]) == k*DefaultPointsPerWeight/10 && nodes[4].Weight() == 1)
+		moved := 0
+		cur := make([]*Node, N)
+		for i := range cur {
+			cur[i] = ring.Get(strconv.Itoa(i))
+			if cur[i] != prev[i] {
+				Must(t, cur[i].Key() == label || prev[i].Key() == label)
+				moved++
+			}
+		}
+		if f := float64(moved) / float64(N); f > 0.03 || math.Abs(f-movement) > 0.005 {
+			t.Errorf("step %d moved %f of the keys, expected %f", k, f, movement)
+		}
+		prev = cur
+	}
+	// The full number of points is the ring of weight 1, which SetWeight
+	// keeps.
+	Must(t, ring.Movement(map[string]int{label: DefaultPointsPerWeight}) == 0)
+	Must(t, ring.SetWeight(label, 1) == nil && ring.PointCount(nodes[4]) == DefaultPointsPerWeight)
+	fresh := NewRing(getServerNodes(10, 1))
+	for i := range before {
+		key := strconv.Itoa(i)
+		Must(t, ring.Get(key).Key() == fresh.Get(key).Key())
+	}
+}
+
+func TestPointsPerWeight(t *testing.T) {
+	nodes := getServerNodes(10, 2)
+	ring := NewRing(nodes)
+	Must(t, len(ring.virtualNodes) == 10*2*DefaultPointsPerWeight)
+	same := NewRing(nodes, WithPointsPerWeight(DefaultPointsPerWeight))
+	for i := range ring.virtualNodes {
+		Must(t, ring.virtualNodes[i] == same.virtualNodes[i])
+	}
+	// Fewer points are a subset of the default ones.
+	few := NewRing(nodes, WithPointsPerWeight(10))
+	Must(t, len(few.virtualNodes) == 10*2*10)
+	all := make(map[point]bool)
+	for _, p := range ring.virtualNodes {
+		all[p] = true
+	}
+	for _, p := range few.virtualNodes {
+		Must(t, all[p])
+	}
+	Must(t, few.SetWeight(nodes[0].Key(), 3) == nil && len(few.virtualNodes) == 10*2*10+10)
+
+	var total float64
+	for _, o := range ring.Ownership() {
+		total += o
+	}
+	Must(t, math.Abs(total-1) < 1e-9)
+	Must(t, few.Imbalance() > ring.Imbalance() && ring.Imbalance() > 1)
+}
+
+func TestTune(t *testing.T) {
+	_, _, err := Tune(getServerNodes(5, 1), 1, 1000)
+	Must(t, err == ErrInvalidTarget)
+	_, _, err = Tune(getServerNodes(5, 1), 1.0001, 8)
+	Must(t, err == ErrTargetUnreachable)
+	for _, maxPoints := range []int{0, -1} {
+		_, _, err = Tune(getServerNodes(5, 1), 1.25, maxPoints)
+		Must(t, err == ErrInvalidMaxPoints)
+	}
+
+	for _, nodeNum := range []uint{5, 20, 100} {
+		for _, target := range []float64{1.25, 1.1} {
+			nodes := getServerNodes(nodeNum, 1)
+			ring, points, err := Tune(nodes, target, 100000, WithSeed(7))
+			Must(t, err == nil)
+			fmt.Printf("%d nodes, target max/avg %.2f: %d points per weight, max/avg %f\n",
+				nodeNum, target, points, ring.Imbalance())
+			Must(t, ring.Imbalance() <= target && ring.points() == points && ring.seed == 7)
+			Must(t, len(ring.virtualNodes) == int(nodeNum)*points)
+			if points > 1 {
+				Must(t, NewRing(nodes, WithSeed(7), WithPointsPerWeight(points-1)).Imbalance() > target)
+			}
+		}
+	}
+
+	// Weights count in the imbalance.
+	nodes := []*Node{NewNode("a", nil, 1), NewNode("b", nil, 3), NewNode("c", nil, 2)}
+	ring, _, err := Tune(nodes, 1.1, 100000)
+	Must(t, err == nil)
+	owned := ring.Ownership()
+	Must(t, owned["b"] > 0.45/1.1 && owned["b"] <= 0.5*1.1 && owned["a"] <= 1.1/6)
+}
+
 func getServerNodes(nodenum, virtualnum uint) []*Node {
 	nodes := make([]*Node, 0, nodenum)
 	var i uint = 0
@@ -154,3 +699,69 @@ func getStandardDeviation(list []float64) float64 {
 
 	return math.Sqrt(dTotal / avg)
 }
+
+func TestGetNZones(t *testing.T) {
+	// Uneven zones: a holds 5 nodes, b 2 and c 1.
+	zoneOf := map[string]string{}
+	var nodes []*Node
+	for i, zone := range []string{"a", "a", "a", "b", "a", "b", "a", "c"} {
+		label := fmt.Sprintf("127.0.0.1:800%d", i)
+		zoneOf[label] = zone
+		nodes = append(nodes, NewNode(label, nil, 1, WithZone(zone)))
+	}
+	ring := NewRing(nodes)
+	primary := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		for _, n := range []int{1, 2, 3} {
+			res := ring.GetNZones(key, n)
+			Must(t, len(res) == n && res[0] == ring.Get(key))
+			zones := make(map[string]bool)
+			for _, node := range res {
+				Must(t, zoneOf[node.Key()] == node.Zone())
+				zones[node.Zone()] = true
+			}
+			if len(zones) != n {
+				t.Fatalf("expected %d zones for %s, got %d", n, key, len(zones))
+			}
+		}
+		primary[ring.Get(key).Key()]++
+
+		// More replicas than zones fall back to distinct nodes in the
+		// used zones.
+		res := ring.GetNZones(key, 5)
+		seen := make(map[*Node]bool)
+		zones := make(map[string]bool)
+		for _, node := range res {
+			seen[node] = true
+			zones[node.Zone()] = true
+		}
+		Must(t, len(res) == 5 && len(seen) == 5 && len(zones) == 3)
+		Must(t, len(ring.GetNZones(key, 100)) == len(nodes))
+	}
+	Must(t, len(primary) == len(nodes))
+	Must(t, NewRing(nil).GetNZones("key", 3) == nil)
+
+	// Without zones GetNZones is GetN.
+	plain := NewRing(getServerNodes(10, 1))
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		a, b := plain.GetN(key, 3), plain.GetNZones(key, 3)
+		Must(t, a[0] == b[0] && a[1] == b[1] && a[2] == b[2])
+	}
+}
+
+func TestPreferZone(t *testing.T) {
+	nodes := []*Node{
+		NewNode("n0", nil, 1, WithZone("a")),
+		NewNode("n1", nil, 1, WithZone("b")),
+		NewNode("n2", nil, 1, WithZone("c")),
+		NewNode("n3", nil, 1, WithZone("b")),
+	}
+	res := PreferZone(nodes, "b")
+	Must(t, res[0] == nodes[1] && res[1] == nodes[3] && res[2] == nodes[0] && res[3] == nodes[2])
+	res = PreferZone(nodes, "d")
+	for i := range nodes {
+		Must(t, res[i] == nodes[i])
+	}
+}
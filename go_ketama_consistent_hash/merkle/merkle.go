@@ -0,0 +1,256 @@
+// Package merkle implements Merkle trees over ketama token ranges for
+// anti-entropy repair, as in Dynamo and Cassandra. Each replica builds a
+// Tree per range it shares with another replica from (key, value digest)
+// pairs; comparing the trees of two replicas gives the sub ranges whose
+// keys differ, so only those need to be exchanged.
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+// MaxDepth is the maximum depth of a Tree, 2^MaxDepth leaves.
+const MaxDepth = 20
+
+var (
+	// ErrInvalidDepth is returned for a depth outside [0, MaxDepth].
+	ErrInvalidDepth = errors.New("merkle: invalid depth")
+	// ErrEmptyRange is returned for a range without any hash.
+	ErrEmptyRange = errors.New("merkle: empty range")
+	// ErrMismatch is returned when comparing trees or forests that do not
+	// cover the same ranges with the same depth.
+	ErrMismatch = errors.New("merkle: trees do not match")
+)
+
+// Tree is a Merkle tree over a range of the ring. The range is split into
+// 2^depth leaves of equal width, each holding the XOR of the hashes of the
+// (key, digest) pairs in it, so the order of insertion does not matter.
+// It is not safe for concurrent use.
+type Tree struct {
+	rng   ketama.Range
+	depth uint
+	// nodes is the tree in heap order: node i has children 2i+1 and 2i+2,
+	// the leaves come last.
+	nodes [][sha256.Size]byte
+	dirty bool
+}
+
+// New returns an empty Tree over rng with 2^depth leaves.
+func New(rng ketama.Range, depth int) (*Tree, error) {
+	if depth < 0 || depth > MaxDepth {
+		return nil, ErrInvalidDepth
+	}
+	if rng.Len() == 0 {
+		return nil, ErrEmptyRange
+	}
+	return &Tree{
+		rng:   rng,
+		depth: uint(depth),
+		nodes: make([][sha256.Size]byte, 2<<uint(depth)-1),
+	}, nil
+}
+
+// Range returns the range of the tree.
+func (t *Tree) Range() ketama.Range {
+	return t.rng
+}
+
+// Insert adds the pair (key, digest) with ring position hash, as returned
+// by ketama.Ring.KeyHash. It returns false if hash is not in the range of
+// the tree. Inserting the same pair again removes it.
+func (t *Tree) Insert(hash uint32, key string, digest []byte) bool {
+	if !t.rng.Contains(hash) {
+		return false
+	}
+	h := entryHash(key, digest)
+	leaf := &t.nodes[t.leafOffset()+t.leaf(hash)]
+	for i := range leaf {
+		leaf[i] ^= h[i]
+	}
+	t.dirty = true
+	return true
+}
+
+// entryHash hashes a pair, length prefixing the key so that different
+// pairs cannot have the same encoding.
+func entryHash(key string, digest []byte) [sha256.Size]byte {
+	b := make([]byte, 8, 8+len(key)+len(digest))
+	binary.BigEndian.PutUint64(b, uint64(len(key)))
+	b = append(b, key...)
+	b = append(b, digest...)
+	return sha256.Sum256(b)
+}
+
+func (t *Tree) leafOffset() int {
+	return 1<<t.depth - 1
+}
+
+// leaf returns the index of the leaf holding hash.
+func (t *Tree) leaf(hash uint32) int {
+	return int((uint64(hash) - t.rng.Start) << t.depth / t.rng.Len())
+}
+
+// leafRange returns the range of the i-th leaf, the hashes leaf maps to i.
+func (t *Tree) leafRange(i int) ketama.Range {
+	return ketama.Range{Start: t.leafStart(i), End: t.leafStart(i + 1)}
+}
+
+// leafStart returns the smallest hash in the i-th leaf, rounding up as leaf
+// rounds down.
+func (t *Tree) leafStart(i int) uint64 {
+	return t.rng.Start + (uint64(i)*t.rng.Len()+1<<t.depth-1)>>t.depth
+}
+
+// Root returns the root hash of the tree.
+func (t *Tree) Root() [sha256.Size]byte {
+	t.rehash()
+	return t.nodes[0]
+}
+
+// rehash recomputes the inner nodes after inserts.
+func (t *Tree) rehash() {
+	if !t.dirty {
+		return
+	}
+	var b [2 * sha256.Size]byte
+	for i := t.leafOffset() - 1; i >= 0; i-- {
+		copy(b[:sha256.Size], t.nodes[2*i+1][:])
+		copy(b[sha256.Size:], t.nodes[2*i+2][:])
+		t.nodes[i] = sha256.Sum256(b[:])
+	}
+	t.dirty = false
+}
+
+// Diff returns the sorted, merged ranges of the leaves that differ between
+// a and b. Both trees must have the same range and depth.
+func Diff(a, b *Tree) ([]ketama.Range, error) {
+	if a.rng != b.rng || a.depth != b.depth {
+		return nil, ErrMismatch
+	}
+	a.rehash()
+	b.rehash()
+	var res []ketama.Range
+	var walk func(i int)
+	walk = func(i int) {
+		if a.nodes[i] == b.nodes[i] {
+			return
+		}
+		if i >= a.leafOffset() {
+			res = appendRange(res, a.leafRange(i-a.leafOffset()))
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return res, nil
+}
+
+// appendRange appends rg to the sorted ranges, merging it with the last one
+// if they touch. Empty ranges are dropped.
+func appendRange(ranges []ketama.Range, rg ketama.Range) []ketama.Range {
+	if rg.Len() == 0 {
+		return ranges
+	}
+	if n := len(ranges); n > 0 && ranges[n-1].End == rg.Start {
+		ranges[n-1].End = rg.End
+		return ranges
+	}
+	return append(ranges, rg)
+}
+
+// Forest holds one Tree for each of a set of ranges, typically those a
+// node shares with another replica.
+type Forest struct {
+	trees []*Tree
+}
+
+// NewForest returns a Forest of empty trees of the given depth over
+// ranges, which must not overlap.
+func NewForest(ranges []ketama.Range, depth int) (*Forest, error) {
+	f := &Forest{trees: make([]*Tree, 0, len(ranges))}
+	for _, rg := range ranges {
+		t, err := New(rg, depth)
+		if err != nil {
+			return nil, err
+		}
+		f.trees = append(f.trees, t)
+	}
+	sort.Slice(f.trees, func(i, j int) bool {
+		return f.trees[i].rng.Start < f.trees[j].rng.Start
+	})
+	return f, nil
+}
+
+// Insert adds the pair (key, digest) to the tree whose range holds hash.
+// It returns false if no range of the forest holds hash.
+func (f *Forest) Insert(hash uint32, key string, digest []byte) bool {
+	i := sort.Search(len(f.trees), func(i int) bool {
+		return f.trees[i].rng.End > uint64(hash)
+	})
+	return i < len(f.trees) && f.trees[i].Insert(hash, key, digest)
+}
+
+// Trees returns the trees of the forest sorted by range.
+func (f *Forest) Trees() []*Tree {
+	res := make([]*Tree, len(f.trees))
+	copy(res, f.trees)
+	return res
+}
+
+// DiffForests returns the sorted ranges whose keys differ between a and b.
+// Both forests must have been built over the same ranges and depth.
+func DiffForests(a, b *Forest) ([]ketama.Range, error) {
+	if len(a.trees) != len(b.trees) {
+		return nil, ErrMismatch
+	}
+	var res []ketama.Range
+	for i := range a.trees {
+		diff, err := Diff(a.trees[i], b.trees[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, rg := range diff {
+			res = appendRange(res, rg)
+		}
+	}
+	return res, nil
+}
+
+// SharedRanges returns the ranges both nodes replicate when keys are
+// stored on the first replicas nodes of ketama.Ring.GetN. These are the
+// ranges two replicas build their forests over to repair each other.
+func SharedRanges(ring *ketama.Ring, a, b string, replicas int) ([]ketama.Range, error) {
+	ra, err := ring.RangesN(a, replicas)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := ring.RangesN(b, replicas)
+	if err != nil {
+		return nil, err
+	}
+	var res []ketama.Range
+	for i, j := 0, 0; i < len(ra) && j < len(rb); {
+		start, end := ra[i].Start, ra[i].End
+		if rb[j].Start > start {
+			start = rb[j].Start
+		}
+		if rb[j].End < end {
+			end = rb[j].End
+		}
+		if start < end {
+			res = append(res, ketama.Range{Start: start, End: end})
+		}
+		if ra[i].End < rb[j].End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return res, nil
+}
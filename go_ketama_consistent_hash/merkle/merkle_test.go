@@ -0,0 +1,208 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strconv"
+	"testing"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(ketama.Range{Start: 0, End: 1 << 32}, MaxDepth+1); err != ErrInvalidDepth {
+		t.Errorf("expected ErrInvalidDepth, got %v", err)
+	}
+	if _, err := New(ketama.Range{Start: 5, End: 5}, 4); err != ErrEmptyRange {
+		t.Errorf("expected ErrEmptyRange, got %v", err)
+	}
+	a, _ := New(ketama.Range{Start: 0, End: 1 << 32}, 4)
+	b, _ := New(ketama.Range{Start: 0, End: 1 << 31}, 4)
+	if _, err := Diff(a, b); err != ErrMismatch {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestLeafRanges(t *testing.T) {
+	// Leaves of uneven width must still tile the range.
+	tree, _ := New(ketama.Range{Start: 10, End: 17}, 2)
+	next := uint64(10)
+	for i := 0; i < 4; i++ {
+		rg := tree.leafRange(i)
+		if rg.Start != next {
+			t.Fatalf("leaf %d starts at %d, expected %d", i, rg.Start, next)
+		}
+		for h := rg.Start; h < rg.End; h++ {
+			if tree.leaf(uint32(h)) != i {
+				t.Errorf("hash %d is in leaf %d, expected %d", h, tree.leaf(uint32(h)), i)
+			}
+		}
+		next = rg.End
+	}
+	if next != 17 {
+		t.Errorf("leaves end at %d, expected 17", next)
+	}
+}
+
+func TestTreeOrder(t *testing.T) {
+	rng := ketama.Range{Start: 0, End: 1 << 32}
+	a, _ := New(rng, 8)
+	b, _ := New(rng, 8)
+	for i := 0; i < 1000; i++ {
+		a.Insert(uint32(i*4294967), strconv.Itoa(i), []byte("v"))
+		j := 999 - i
+		b.Insert(uint32(j*4294967), strconv.Itoa(j), []byte("v"))
+	}
+	if a.Root() != b.Root() {
+		t.Error("the root must not depend on the insertion order")
+	}
+	if !a.Insert(42, "extra", nil) || a.Root() == b.Root() {
+		t.Error("an extra pair must change the root")
+	}
+	a.Insert(42, "extra", nil)
+	if a.Root() != b.Root() {
+		t.Error("inserting a pair twice must remove it")
+	}
+	c, _ := New(ketama.Range{Start: 100, End: 200}, 2)
+	if c.Insert(99, "out", nil) || c.Insert(200, "out", nil) {
+		t.Error("hashes outside the range must be rejected")
+	}
+}
+
+func digest(v string) []byte {
+	d := md5.Sum([]byte(v))
+	return d[:]
+}
+
+// replica is an in-memory replica holding the keys it stores.
+type replica map[string]string
+
+func (r replica) forest(ring *ketama.Ring, shared []ketama.Range, depth int) *Forest {
+	f, _ := NewForest(shared, depth)
+	for k, v := range r {
+		f.Insert(ring.KeyHash(k), k, digest(v))
+	}
+	return f
+}
+
+func TestRepair(t *testing.T) {
+	var nodes []*ketama.Node
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, ketama.NewNode(fmt.Sprintf("127.0.0.1:800%d", i), nil, 1))
+	}
+	ring := ketama.NewRing(nodes)
+	a, b := nodes[0].Key(), nodes[1].Key()
+	shared, err := SharedRanges(ring, a, b, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sharedLen uint64
+	for _, rg := range shared {
+		sharedLen += rg.Len()
+	}
+
+	// Both replicas store the keys replicated to them.
+	ra, rb := replica{}, replica{}
+	for i := 0; i < 100000; i++ {
+		key := "key" + strconv.Itoa(i)
+		for _, n := range ring.GetN(key, 3) {
+			if n.Key() == a {
+				ra[key] = "value" + strconv.Itoa(i)
+			}
+			if n.Key() == b {
+				rb[key] = "value" + strconv.Itoa(i)
+			}
+		}
+	}
+	depth := 10
+	diff, _ := DiffForests(ra.forest(ring, shared, depth), rb.forest(ring, shared, depth))
+	if len(diff) != 0 {
+		t.Fatalf("expected identical replicas, got %v", diff)
+	}
+
+	// Inject differences in the keys both replicas store: an updated
+	// value, a missing key and an extra key.
+	var injected []string
+	for k := range rb {
+		if _, ok := ra[k]; !ok {
+			continue
+		}
+		switch len(injected) {
+		case 0:
+			rb[k] = "stale"
+		case 1:
+			delete(rb, k)
+		default:
+			delete(ra, k)
+		}
+		injected = append(injected, k)
+		if len(injected) == 3 {
+			break
+		}
+	}
+	diff, err = DiffForests(ra.forest(ring, shared, depth), rb.forest(ring, shared, depth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diffLen uint64
+	for _, rg := range diff {
+		diffLen += rg.Len()
+	}
+	fmt.Printf("%d differing ranges covering %f of the shared ranges\n", len(diff), float64(diffLen)/float64(sharedLen))
+	for _, k := range injected {
+		h := ring.KeyHash(k)
+		found := false
+		for _, rg := range diff {
+			found = found || rg.Contains(h)
+		}
+		if !found {
+			t.Errorf("differing key %s is not in a differing range", k)
+		}
+	}
+	if len(diff) > 3 || float64(diffLen) > 0.01*float64(sharedLen) {
+		t.Errorf("expected at most 3 small differing ranges, got %v", diff)
+	}
+
+	// Keys outside the shared ranges are not compared.
+	f, _ := NewForest(shared, depth)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		in := false
+		for _, rg := range shared {
+			in = in || rg.Contains(ring.KeyHash(key))
+		}
+		if f.Insert(ring.KeyHash(key), key, nil) != in {
+			t.Fatalf("Insert(%s) must report whether it is in a shared range", key)
+		}
+	}
+}
+
+func TestSharedRanges(t *testing.T) {
+	a := ketama.NewNode("a", nil, 1, ketama.WithTokens([]uint32{0, 1 << 30, 2 << 30, 3 << 30}))
+	b := ketama.NewNode("b", nil, 1, ketama.WithTokens([]uint32{1 << 29, 3 << 29}))
+	c := ketama.NewNode("c", nil, 1, ketama.WithTokens([]uint32{7 << 29}))
+	ring, _ := ketama.NewTokenRing([]*ketama.Node{a, b, c})
+	shared, _ := SharedRanges(ring, "a", "b", 1)
+	if len(shared) != 0 {
+		t.Errorf("primary ranges do not overlap, got %v", shared)
+	}
+	shared, _ = SharedRanges(ring, "a", "b", 2)
+	ra, _ := ring.RangesN("a", 2)
+	rb, _ := ring.RangesN("b", 2)
+	for h := uint64(0); h < 1<<32; h += 1 << 26 {
+		in := func(ranges []ketama.Range) bool {
+			for _, rg := range ranges {
+				if rg.Contains(uint32(h)) {
+					return true
+				}
+			}
+			return false
+		}
+		if in(shared) != (in(ra) && in(rb)) {
+			t.Errorf("hash %d: shared ranges %v disagree with %v and %v", h, shared, ra, rb)
+		}
+	}
+	if _, err := SharedRanges(ring, "a", "d", 2); err != ketama.ErrNodeNotFound {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package ramp changes the weight of ketama nodes gradually. A cold cache
+// node added at full weight takes its share of the keys at once and every
+// one of them misses; ramping its weight up over several steps spreads the
+// misses out. Draining a node ramps its weight down to 0 the same way.
+// Ramps move the number of points of the node, Ring.SetPoints, rather than
+// its weight, so that a node of weight 1 ramps as smoothly as a heavy one.
+package ramp
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+var (
+	// ErrRampInProgress is returned when starting a ramp for a node that
+	// is already ramping.
+	ErrRampInProgress = errors.New("ramp: node is already ramping")
+	// ErrInvalidSteps is returned for fewer than 1 step.
+	ErrInvalidSteps = errors.New("ramp: invalid number of steps")
+	// ErrMaxMove is returned when a single point moves more than the
+	// per-step movement bound.
+	ErrMaxMove = errors.New("ramp: movement bound too small for the ring")
+)
+
+// Clock tells the time. Tests inject a fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock of the system.
+var SystemClock Clock = systemClock{}
+
+// ramp moves the points of a node from from to to in steps, the k-th at
+// start + k*interval, and then sets its weight.
+type ramp struct {
+	from, to int
+	current  int
+	weight   uint
+	steps    int
+	step     int
+	start    time.Time
+	interval time.Duration
+}
+
+// points returns the points after the k-th step.
+func (r *ramp) points(k int) int {
+	return r.from + (r.to-r.from)*k/r.steps
+}
+
+// Scheduler runs the ramps of the nodes of a ring. Call Tick periodically,
+// each call takes the steps that are due.
+// It is not safe for concurrent use, nor is the ring.
+type Scheduler struct {
+	ring    *ketama.Ring
+	clock   Clock
+	maxMove float64
+	ramps   map[string]*ramp
+}
+
+// NewScheduler returns a Scheduler for ring. If maxMove is positive, ramps
+// get as many more steps as needed for each step to move at most that
+// fraction of the keys, and a step that would still move more is taken
+// over several ticks.
+func NewScheduler(ring *ketama.Ring, clock Clock, maxMove float64) *Scheduler {
+	return &Scheduler{ring: ring, clock: clock, maxMove: maxMove, ramps: make(map[string]*ramp)}
+}
+
+// WarmUp adds node to the ring without points and ramps it up to the
+// points of its weight over steps steps spread over duration.
+func (s *Scheduler) WarmUp(node *ketama.Node, steps int, duration time.Duration) error {
+	if _, ok := s.ramps[node.Key()]; ok {
+		return ErrRampInProgress
+	}
+	if steps < 1 {
+		return ErrInvalidSteps
+	}
+	target := node.Weight()
+	if err := s.ring.Add(node); err != nil {
+		return err
+	}
+	s.ring.SetPoints(node.Key(), 0)
+	if err := s.Start(node.Key(), target, steps, duration); err != nil {
+		s.ring.Remove(node.Key())
+		return err
+	}
+	return nil
+}
+
+// Drain ramps the node labeled label down to weight 0 over steps steps
+// spread over duration. The node stays on the ring without keys.
+func (s *Scheduler) Drain(label string, steps int, duration time.Duration) error {
+	return s.Start(label, 0, steps, duration)
+}
+
+// Start ramps the points of the node labeled label from its current number
+// to the points of weight target over steps steps spread over duration,
+// then sets its weight to target. The first step is due one interval,
+// duration/steps, after the start.
+func (s *Scheduler) Start(label string, target uint, steps int, duration time.Duration) error {
+	if _, ok := s.ramps[label]; ok {
+		return ErrRampInProgress
+	}
+	if steps < 1 {
+		return ErrInvalidSteps
+	}
+	current, others := 0, 0
+	found := false
+	for _, n := range s.ring.Nodes() {
+		if n.Key() == label {
+			current, found = s.ring.PointCount(n), true
+		} else {
+			others += s.ring.PointCount(n)
+		}
+	}
+	if !found {
+		return ketama.ErrNodeNotFound
+	}
+	to := int(target) * s.ring.PointsPerWeight()
+	if s.maxMove > 0 {
+		// A step changing the points by d moves about d/others of the
+		// keys.
+		d := int(s.maxMove * float64(others))
+		if d == 0 {
+			return ErrMaxMove
+		}
+		delta := to - current
+		if delta < 0 {
+			delta = -delta
+		}
+		if min := (delta + d - 1) / d; min > steps {
+			steps = min
+		}
+	}
+	s.ramps[label] = &ramp{
+		from:     current,
+		to:       to,
+		current:  current,
+		weight:   target,
+		steps:    steps,
+		start:    s.clock.Now(),
+		interval: duration / time.Duration(steps),
+	}
+	return nil
+}
+
+// Tick takes, for each ramp, the next step if it is due, and returns the
+// new number of points of the nodes it changed. A late Tick takes a single
+// step per ramp, so the movement bound of a step holds; the ramp catches up
+// on the next ticks. A step moving more than the bound is cut short, the
+// rest of it is taken on the next ticks.
+func (s *Scheduler) Tick() map[string]int {
+	now := s.clock.Now()
+	res := make(map[string]int)
+	labels := make([]string, 0, len(s.ramps))
+	for label := range s.ramps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		r := s.ramps[label]
+		if now.Before(r.start.Add(time.Duration(r.step+1) * r.interval)) {
+			continue
+		}
+		p := s.bound(label, r.current, r.points(r.step+1))
+		if p == r.points(r.step+1) {
+			r.step++
+		}
+		var err error
+		if r.step == r.steps {
+			err = s.ring.SetWeight(label, r.weight)
+		} else {
+			err = s.ring.SetPoints(label, p)
+		}
+		if err != nil {
+			// The node left the ring.
+			delete(s.ramps, label)
+			continue
+		}
+		r.current = p
+		res[label] = p
+		if r.step == r.steps {
+			delete(s.ramps, label)
+		}
+	}
+	return res
+}
+
+// bound returns the number of points between current and want closest to
+// want that moves at most maxMove of the keys, but at least one point away
+// from current. The keys moved grow with the number of points changed.
+func (s *Scheduler) bound(label string, current, want int) int {
+	moves := func(p int) bool {
+		return s.ring.Movement(map[string]int{label: p}) > s.maxMove
+	}
+	if s.maxMove <= 0 || current == want || !moves(want) {
+		return want
+	}
+	ok, over := current, want
+	for ok-over > 1 || over-ok > 1 {
+		mid := ok + (over-ok)/2
+		if moves(mid) {
+			over = mid
+		} else {
+			ok = mid
+		}
+	}
+	if ok == current {
+		return over
+	}
+	return ok
+}
+
+// Ramping returns the labels of the nodes with a ramp in progress, sorted.
+func (s *Scheduler) Ramping() []string {
+	res := make([]string, 0, len(s.ramps))
+	for label := range s.ramps {
+		res = append(res, label)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Cancel stops the ramp of the node labeled label, leaving its current
+// points.
+func (s *Scheduler) Cancel(label string) {
+	delete(s.ramps, label)
+}
@@ -0,0 +1,202 @@
+package ramp
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newRing(n int, weight uint) *ketama.Ring {
+	var nodes []*ketama.Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, ketama.NewNode(fmt.Sprintf("127.0.0.1:800%d", i), nil, weight))
+	}
+	return ketama.NewRing(nodes)
+}
+
+func placement(ring *ketama.Ring) []string {
+	res := make([]string, 20000)
+	for i := range res {
+		if n := ring.Get(strconv.Itoa(i)); n != nil {
+			res[i] = n.Key()
+		}
+	}
+	return res
+}
+
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestWarmUp(t *testing.T) {
+	// Nodes of weight 1, as built by the Picker.
+	ring := newRing(9, 1)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := NewScheduler(ring, clock, 0.02)
+	node := ketama.NewNode("127.0.0.1:9000", nil, 1)
+	before := placement(ring)
+	if err := s.WarmUp(node, 10, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if m := moved(before, placement(ring)); m != 0 || ring.PointCount(node) != 0 {
+		t.Fatalf("a warming node must start without keys, moved %f", m)
+	}
+	if err := s.WarmUp(node, 2, time.Minute); err != ErrRampInProgress {
+		t.Errorf("expected ErrRampInProgress, got %v", err)
+	}
+
+	// 10 steps of 16 points, each moving about 1% of the keys.
+	if changed := s.Tick(); len(changed) != 0 {
+		t.Errorf("no step is due yet, got %v", changed)
+	}
+	steps := 0
+	for len(s.Ramping()) > 0 {
+		clock.Advance(6 * time.Second)
+		before = placement(ring)
+		changed := s.Tick()
+		if len(changed) != 1 {
+			t.Fatalf("expected a step every 6s, got %v", changed)
+		}
+		steps++
+		m := moved(before, placement(ring))
+		if m == 0 || m > 0.02 {
+			t.Errorf("step %d moved %f of the keys, bound 0.02", steps, m)
+		}
+		if changed[node.Key()] != 16*steps {
+			t.Errorf("expected %d points after step %d, got %d", 16*steps, steps, changed[node.Key()])
+		}
+	}
+	if steps != 10 || node.Weight() != 1 || ring.PointCount(node) != ring.PointsPerWeight() {
+		t.Errorf("expected 10 steps to weight 1, got %d to %d points", steps, ring.PointCount(node))
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ring := newRing(10, 1)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := NewScheduler(ring, clock, 0)
+	label := ring.Nodes()[3].Key()
+	if err := s.Drain("unknown", 5, time.Minute); err != ketama.ErrNodeNotFound {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+	if err := s.Drain(label, 0, time.Minute); err != ErrInvalidSteps {
+		t.Errorf("expected ErrInvalidSteps, got %v", err)
+	}
+	if err := s.Drain(label, 4, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// A late tick takes one step only.
+	clock.Advance(time.Hour)
+	var points []int
+	for len(s.Ramping()) > 0 {
+		before := placement(ring)
+		changed := s.Tick()
+		points = append(points, changed[label])
+		// Keys only leave the drained node.
+		after := placement(ring)
+		for i := range before {
+			if before[i] != after[i] && before[i] != label {
+				t.Fatalf("key %d moved from %s to %s", i, before[i], after[i])
+			}
+		}
+	}
+	if fmt.Sprint(points) != "[120 80 40 0]" {
+		t.Errorf("expected points [120 80 40 0], got %v", points)
+	}
+	if ring.Nodes()[3].Weight() != 0 {
+		t.Error("a drained node must end with weight 0")
+	}
+	for _, node := range placement(ring) {
+		if node == label {
+			t.Fatal("a drained node must not own keys")
+		}
+	}
+}
+
+func TestMaxMove(t *testing.T) {
+	ring := newRing(1, 1)
+	s := NewScheduler(ring, &fakeClock{}, 0.005)
+	if err := s.WarmUp(ketama.NewNode("127.0.0.1:9000", nil, 5), 1, time.Minute); err != ErrMaxMove {
+		t.Errorf("expected ErrMaxMove, got %v", err)
+	}
+	if len(ring.Nodes()) != 1 {
+		t.Error("a failed warm-up must not leave the node on the ring")
+	}
+
+	ring = newRing(10, 1)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s = NewScheduler(ring, clock, 0.05)
+	label := ring.Nodes()[0].Key()
+	if err := s.Start(label, 4, 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// 5% of the 1440 other points is 72 points per step: 480/72, at least
+	// 7 steps, more if a step is cut short.
+	ticks := 0
+	for len(s.Ramping()) > 0 {
+		clock.Advance(time.Minute / 7)
+		before := placement(ring)
+		if len(s.Tick()) != 1 {
+			t.Fatalf("expected a step every %s", time.Minute/7)
+		}
+		ticks++
+		if m := moved(before, placement(ring)); m > 0.05 {
+			t.Errorf("tick %d moved %f of the keys, bound 0.05", ticks, m)
+		}
+	}
+	if ticks < 7 || ring.Nodes()[0].Weight() != 4 {
+		t.Errorf("expected at least 7 ticks to weight 4, got %d to %d", ticks, ring.Nodes()[0].Weight())
+	}
+
+	// On a small ring a few points own more than their share, the steps
+	// moving too much are cut short.
+	small := newRing(3, 1)
+	s2 := NewScheduler(small, clock, 0.03)
+	node := ketama.NewNode("127.0.0.1:9000", nil, 1)
+	if err := s2.WarmUp(node, 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	ticks = 0
+	for len(s2.Ramping()) > 0 {
+		clock.Advance(time.Minute)
+		before := placement(small)
+		s2.Tick()
+		ticks++
+		if m := moved(before, placement(small)); m > 0.03 {
+			t.Errorf("tick %d moved %f of the keys, bound 0.03", ticks, m)
+		}
+	}
+	fmt.Printf("warmed up a fourth node in %d ticks\n", ticks)
+	if small.PointCount(node) != small.PointsPerWeight() {
+		t.Errorf("expected the ramp to end at weight 1, got %d points", small.PointCount(node))
+	}
+
+	s.Start(label, 1, 4, time.Minute)
+	s.Cancel(label)
+	clock.Advance(time.Hour)
+	if len(s.Tick()) != 0 || ring.PointCount(ring.Nodes()[0]) != 4*ring.PointsPerWeight() {
+		t.Error("a cancelled ramp must not change the points")
+	}
+}
@@ -0,0 +1,158 @@
+package ketama
+
+import "sort"
+
+// Range is the interval [Start, End) of the 32 bit hash space. End is at
+// most 1<<32.
+type Range struct {
+	Start uint64
+	End   uint64
+}
+
+// Len returns the number of hashes in the range.
+func (rg Range) Len() uint64 {
+	return rg.End - rg.Start
+}
+
+// Contains reports whether hash is in the range.
+func (rg Range) Contains(hash uint32) bool {
+	return uint64(hash) >= rg.Start && uint64(hash) < rg.End
+}
+
+// Ranges returns the ranges of key hashes the node labeled NodeLable is
+// the primary owner of, sorted and merged. A range wrapping around the end
+// of the hash space is split in two. Down nodes keep their ranges.
+func (r *Ring) Ranges(NodeLable string) ([]Range, error) {
+	return r.RangesN(NodeLable, 1)
+}
+
+// RangesN returns the ranges of key hashes for which the node labeled
+// NodeLable is one of the first replicas nodes GetN returns, sorted and
+// merged. Down nodes keep their ranges.
+func (r *Ring) RangesN(NodeLable string, replicas int) ([]Range, error) {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return nil, ErrNodeNotFound
+	}
+	node := r.nodes[i]
+	var ranges []Range
+	for i := range r.virtualNodes {
+		if r.replicaOf(i, node, replicas) {
+			ranges = append(ranges, r.pointRanges(i)...)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	var res []Range
+	for _, rg := range ranges {
+		if n := len(res); n > 0 && res[n-1].End == rg.Start {
+			res[n-1].End = rg.End
+		} else {
+			res = append(res, rg)
+		}
+	}
+	return res, nil
+}
+
+// replicaOf reports whether node is among the first replicas distinct
+// nodes found walking the ring clockwise from point i.
+func (r *Ring) replicaOf(i int, node *Node, replicas int) bool {
+	seen := make(map[*Node]bool, replicas)
+	for j := 0; j < len(r.virtualNodes) && len(seen) < replicas; j++ {
+		n := r.virtualNodes[(i+j)%len(r.virtualNodes)].node
+		if n == node {
+			return true
+		}
+		seen[n] = true
+	}
+	return false
+}
+
+// pointRanges returns the key hashes owned by point i, [points[i-1].hash,
+// points[i].hash), split in two if it wraps around. Point 0 owns the
+// wraparound range, and the whole space if all points share one hash.
+func (r *Ring) pointRanges(i int) []Range {
+	points := r.virtualNodes
+	end := uint64(points[i].hash)
+	if i > 0 {
+		if start := uint64(points[i-1].hash); start < end {
+			return []Range{{start, end}}
+		}
+		return nil
+	}
+	start := uint64(points[len(points)-1].hash)
+	if start == end {
+		return []Range{{0, ringSize}}
+	}
+	res := []Range{{start, ringSize}}
+	if end > 0 {
+		res = append(res, Range{0, end})
+	}
+	return res
+}
+
+// KeyHash returns the position of key on the ring, the hash Ranges are
+// made of.
+func (r *Ring) KeyHash(key string) uint32 {
+	return r.keyHash(key)
+}
+
+// Movement returns the fraction of the hash space, so of the keys, that
+// would change node if the nodes labeled by the keys of points had that
+// many points, as set by SetPoints. Unknown labels and nodes with explicit
+// tokens are left out. The ring is not changed.
+func (r *Ring) Movement(points map[string]int) float64 {
+	if len(r.virtualNodes) == 0 {
+		return 0
+	}
+	var added []point
+	changed := make(map[*Node]bool, len(points))
+	for _, node := range r.nodes {
+		n, ok := points[node.NodeLable]
+		if !ok || node.tokens != nil || n == r.PointCount(node) {
+			continue
+		}
+		changed[node] = true
+		pinned := *node
+		pinned.points, pinned.pinned = n, true
+		for _, p := range r.nodePoints(&pinned) {
+			added = append(added, point{hash: p.hash, node: node})
+		}
+	}
+	if len(changed) == 0 {
+		return 0
+	}
+	kept := make([]point, 0, len(r.virtualNodes))
+	for _, p := range r.virtualNodes {
+		if !changed[p.node] {
+			kept = append(kept, p)
+		}
+	}
+	sortPoints(added)
+	next := mergePoints(kept, added)
+	if len(next) == 0 {
+		return 1
+	}
+
+	// Keys in [start, end) between two consecutive hashes of either ring
+	// go to the same node on each ring.
+	starts := append([]point{{}}, mergePoints(r.virtualNodes, next)...)
+	var moved uint64
+	i, j := 0, 0
+	for k, p := range starts {
+		end := uint64(ringSize)
+		if k+1 < len(starts) {
+			end = uint64(starts[k+1].hash)
+		}
+		// i and j are the clockwise successors of p.hash on each ring.
+		for ; i < len(r.virtualNodes) && r.virtualNodes[i].hash <= p.hash; i++ {
+		}
+		for ; j < len(next) && next[j].hash <= p.hash; j++ {
+		}
+		if end != uint64(p.hash) && r.virtualNodes[i%len(r.virtualNodes)].node != next[j%len(next)].node {
+			moved += end - uint64(p.hash)
+		}
+	}
+	return float64(moved) / ringSize
+}
@@ -0,0 +1,194 @@
+package ketama
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TokenCollisionError is returned when an explicit token of a node is
+// already used by another point of the ring, or twice by the node itself.
+type TokenCollisionError struct {
+	Token uint32
+	Node  string
+	Other string
+}
+
+func (e *TokenCollisionError) Error() string {
+	return fmt.Sprintf("ketama: token %d of node %s collides with node %s", e.Token, e.Node, e.Other)
+}
+
+// WithTokens places the node on the ring at the given tokens, like
+// Cassandra's initial_token, instead of at points derived from its label.
+// Its weight is then only used by AllocateTokens to balance the other nodes
+// against it.
+func WithTokens(tokens []uint32) NodeOption {
+	t := make([]uint32, len(tokens))
+	copy(t, tokens)
+	return func(n *Node) {
+		n.tokens = t
+	}
+}
+
+// Tokens returns the explicit tokens of the Node, nil if its points are
+// derived from its label.
+func (n *Node) Tokens() []uint32 {
+	if n.tokens == nil {
+		return nil
+	}
+	res := make([]uint32, len(n.tokens))
+	copy(res, n.tokens)
+	return res
+}
+
+// NewTokenRing creates a new Ring like NewRing, but checks the nodes: it
+// returns ErrNodeExists for a duplicate label and a *TokenCollisionError if
+// an explicit token collides with another point. Points derived from
+// labels may collide with each other, as in NewRing.
+func NewTokenRing(realsNodes []*Node, opts ...Option) (*Ring, error) {
+	r := NewRing(nil, opts...)
+	for _, node := range realsNodes {
+		if err := r.Add(node); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// checkTokens returns a *TokenCollisionError if an explicit token of node
+// is already on the ring or repeated.
+func (r *Ring) checkTokens(node *Node) error {
+	seen := make(map[uint32]bool, len(node.tokens))
+	for _, t := range node.tokens {
+		if seen[t] {
+			return &TokenCollisionError{Token: t, Node: node.NodeLable, Other: node.NodeLable}
+		}
+		seen[t] = true
+		i := sort.Search(len(r.virtualNodes), func(i int) bool {
+			return r.virtualNodes[i].hash >= t
+		})
+		if i < len(r.virtualNodes) && r.virtualNodes[i].hash == t {
+			return &TokenCollisionError{Token: t, Node: node.NodeLable, Other: r.virtualNodes[i].node.NodeLable}
+		}
+	}
+	if node.tokens == nil {
+		// Points derived from the label only collide with explicit tokens.
+		for _, p := range r.nodePoints(node) {
+			i := sort.Search(len(r.virtualNodes), func(i int) bool {
+				return r.virtualNodes[i].hash >= p.hash
+			})
+			for ; i < len(r.virtualNodes) && r.virtualNodes[i].hash == p.hash; i++ {
+				if other := r.virtualNodes[i].node; other.tokens != nil {
+					return &TokenCollisionError{Token: p.hash, Node: other.NodeLable, Other: node.NodeLable}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ringSize is the size of the 32 bit hash space.
+const ringSize = 1 << 32
+
+// ownership returns the length of the hash range owned by each point of
+// points, which must be sorted. Point i owns [points[i-1].hash,
+// points[i].hash), the first one also owns the wraparound.
+func ownership(points []point) []uint64 {
+	res := make([]uint64, len(points))
+	if len(points) == 0 {
+		return res
+	}
+	if points[0].hash == points[len(points)-1].hash {
+		res[0] = ringSize
+		return res
+	}
+	for i := range points {
+		prev := points[(i+len(points)-1)%len(points)].hash
+		res[i] = uint64(points[i].hash - prev)
+	}
+	return res
+}
+
+// AllocateTokens returns n tokens for a node of the given weight joining
+// the ring. Each token takes part of the largest range of the node owning
+// the most above its share once the new node has joined, so the new node
+// ends up with about its share of the ring taken from the most loaded
+// nodes. A token splits a single range, so n should be about the number of
+// points other nodes have for that weight, DefaultPointsPerWeight per
+// weight by default. On an empty ring the tokens are evenly spaced.
+func (r *Ring) AllocateTokens(n int, weight uint) []uint32 {
+	if n <= 0 {
+		return nil
+	}
+	res := make([]uint32, 0, n)
+	if len(r.virtualNodes) == 0 {
+		for i := 0; i < n; i++ {
+			res = append(res, uint32(uint64(i)*ringSize/uint64(n)))
+		}
+		return res
+	}
+
+	var total uint64
+	for _, node := range r.nodes {
+		total += uint64(node.weight)
+	}
+	total += uint64(weight)
+	share := func(w uint) uint64 {
+		if total == 0 {
+			return 0
+		}
+		return ringSize * uint64(w) / total
+	}
+
+	points := make([]point, len(r.virtualNodes))
+	copy(points, r.virtualNodes)
+	joining := &Node{weight: weight}
+	for len(res) < n {
+		owned := ownership(points)
+		byNode := make(map[*Node]uint64, len(r.nodes)+1)
+		for i, p := range points {
+			byNode[p.node] += owned[i]
+		}
+
+		// The donor is the node most above its share with a range to split.
+		var donor *Node
+		var excess int64
+		largest := make(map[*Node]int)
+		for i, p := range points {
+			if p.node == joining || owned[i] < 2 {
+				continue
+			}
+			if j, ok := largest[p.node]; !ok || owned[i] > owned[j] {
+				largest[p.node] = i
+			}
+		}
+		for _, node := range r.nodes {
+			if _, ok := largest[node]; !ok {
+				continue
+			}
+			if e := int64(byNode[node]) - int64(share(node.weight)); donor == nil || e > excess {
+				donor, excess = node, e
+			}
+		}
+		if donor == nil {
+			break
+		}
+
+		i := largest[donor]
+		want := (int64(share(weight)) - int64(byNode[joining])) / int64(n-len(res))
+		if want > excess {
+			want = excess
+		}
+		if want > int64(owned[i])-1 {
+			want = int64(owned[i]) - 1
+		}
+		if want < 1 {
+			want = 1
+		}
+		start := points[(i+len(points)-1)%len(points)].hash
+		t := start + uint32(want)
+		res = append(res, t)
+		points = append(points, point{hash: t, node: joining})
+		sortPoints(points)
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package ketama
+
+import "errors"
+
+var (
+	// ErrInvalidTarget is returned by Tune for a target imbalance not
+	// above 1.
+	ErrInvalidTarget = errors.New("ketama: target imbalance must be above 1")
+	// ErrTargetUnreachable is returned by Tune when even the largest
+	// number of points misses the target.
+	ErrTargetUnreachable = errors.New("ketama: target imbalance unreachable")
+	// ErrInvalidMaxPoints is returned by Tune for a largest number of
+	// points below 1.
+	ErrInvalidMaxPoints = errors.New("ketama: max points must be at least 1")
+)
+
+// Ownership returns the fraction of the hash space each node owns, keyed
+// by label. Down nodes keep their share.
+func (r *Ring) Ownership() map[string]float64 {
+	res := make(map[string]float64, len(r.nodes))
+	for _, node := range r.nodes {
+		res[node.NodeLable] = 0
+	}
+	for i, o := range ownership(r.virtualNodes) {
+		res[r.virtualNodes[i].node.NodeLable] += float64(o) / ringSize
+	}
+	return res
+}
+
+// Imbalance returns the largest ratio of the ownership of a node to its
+// share of the total weight, 1 for a perfectly balanced ring. Nodes of
+// weight 0 are left out.
+func (r *Ring) Imbalance() float64 {
+	var total uint64
+	for _, node := range r.nodes {
+		total += uint64(node.weight)
+	}
+	if total == 0 {
+		return 0
+	}
+	owned := r.Ownership()
+	var res float64
+	for _, node := range r.nodes {
+		if node.weight == 0 {
+			continue
+		}
+		if ratio := owned[node.NodeLable] / (float64(node.weight) / float64(total)); ratio > res {
+			res = ratio
+		}
+	}
+	return res
+}
+
+// Tune returns a ring over nodes with the fewest points per weight, up to
+// maxPoints, whose Imbalance is at most target, and that number. It doubles
+// the points until the target is met, then bisects down to the smallest
+// number meeting it. The imbalance does not strictly decrease with the
+// points, so a smaller number may meet the target by chance; the one
+// returned is the smallest above a number that misses it.
+// opts configure the ring as for NewRing, the points per weight excepted.
+func Tune(nodes []*Node, target float64, maxPoints int, opts ...Option) (*Ring, int, error) {
+	if target <= 1 {
+		return nil, 0, ErrInvalidTarget
+	}
+	if maxPoints < 1 {
+		return nil, 0, ErrInvalidMaxPoints
+	}
+	build := func(points int) *Ring {
+		return NewRing(nodes, append(opts[:len(opts):len(opts)], WithPointsPerWeight(points))...)
+	}
+
+	lo, hi := 0, 1
+	ring := build(hi)
+	for ring.Imbalance() > target {
+		if hi >= maxPoints {
+			return nil, 0, ErrTargetUnreachable
+		}
+		lo, hi = hi, 2*hi
+		if hi > maxPoints {
+			hi = maxPoints
+		}
+		ring = build(hi)
+	}
+	// lo misses the target, or is 0, and hi meets it.
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if r := build(mid); r.Imbalance() <= target {
+			hi, ring = mid, r
+		} else {
+			lo = mid
+		}
+	}
+	return ring, hi, nil
+}
@@ -0,0 +1,81 @@
+package ketama
+
+// GetNZones returns up to n distinct nodes for key, spread over as many
+// zones as possible. Like Cassandra's NetworkTopologyStrategy it walks the
+// ring clockwise from the node Get returns and takes the first node of each
+// zone it has not seen yet. Nodes in zones already holding a replica are
+// set aside, and used in ring order once every zone holds one, so with
+// fewer zones than n the result still has n nodes.
+// Nodes created without WithZone are all in zone "". Down nodes are
+// skipped.
+// Returns nil if the ring is empty.
+func (r *Ring) GetNZones(key string, n int) []*Node {
+	if n > len(r.nodes)-len(r.down) {
+		n = len(r.nodes) - len(r.down)
+	}
+	if n <= 0 || len(r.virtualNodes) == 0 {
+		return nil
+	}
+	zones := make(map[string]bool)
+	for _, node := range r.nodes {
+		if !r.down[node] {
+			zones[node.zone] = true
+		}
+	}
+	res := make([]*Node, 0, n)
+	var skipped []*Node
+	seen := make(map[*Node]bool, n)
+	used := make(map[string]bool, len(zones))
+	start := r.search(r.keyHash(key))
+	for i := 0; i < len(r.virtualNodes) && len(res) < n; i++ {
+		if len(used) == len(zones) && len(res)+len(skipped) >= n {
+			break
+		}
+		node := r.virtualNodes[(start+i)%len(r.virtualNodes)].node
+		if seen[node] || r.down[node] {
+			continue
+		}
+		seen[node] = true
+		if !used[node.zone] {
+			used[node.zone] = true
+			res = append(res, node)
+		} else {
+			skipped = append(skipped, node)
+		}
+	}
+	for _, node := range skipped {
+		if len(res) == n {
+			break
+		}
+		res = append(res, node)
+	}
+	return res
+}
+
+// PreferZone returns nodes with those in zone first, keeping the order
+// otherwise. Reading replicas in this order favours the local zone.
+func PreferZone(nodes []*Node, zone string) []*Node {
+	res := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.zone == zone {
+			res = append(res, node)
+		}
+	}
+	for _, node := range nodes {
+		if node.zone != zone {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// SetZone moves the node labeled NodeLable to zone in place. No key moves
+// for Get, only GetNZones spreads replicas differently.
+func (r *Ring) SetZone(NodeLable, zone string) error {
+	i := r.index(NodeLable)
+	if i < 0 {
+		return ErrNodeNotFound
+	}
+	r.nodes[i].zone = zone
+	return nil
+}
@@ -0,0 +1,326 @@
+// Package maglev implements Maglev hashing, the consistent hashing used by
+// Google's Maglev network load balancer[1]. Every backend fills slots of a
+// prime sized lookup table following its own permutation, so lookups are a
+// single table access and every backend owns nearly the same number of slots.
+//
+// [1] https://static.googleusercontent.com/media/research.google.com/en//pubs/archive/44824.pdf
+package maglev
+
+import (
+	"errors"
+	"sort"
+
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// DefaultSize is a prime table size good for up to a few hundred backends.
+// The table should be at least 100 times larger than the number of backends
+// to keep the imbalance under 1%.
+const DefaultSize = 65537
+
+// ErrInvalidSize is returned when the table size is not a prime number.
+var ErrInvalidSize = errors.New("maglev: table size must be a prime number")
+
+// Hasher hashes a key or a backend name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Backend is a named backend. A backend with weight w gets w/max(weight)
+// as many table slots as the heaviest backend.
+type Backend struct {
+	Name   string
+	Weight uint
+}
+
+// Maglev is a Maglev lookup table. New fills the table with the population
+// algorithm of the paper. Add and Remove then only refill the slots that
+// change hands, so only the keys of the added or removed backend move, but
+// the table depends on the order of the changes: Rebuild refills the whole
+// table when balancers with the same backends must agree on every slot.
+// It is not safe for concurrent use.
+type Maglev struct {
+	size     uint64
+	hash     Hasher
+	backends map[string]*backend
+	// sorted holds the backends sorted by name so the table does not depend
+	// on the order backends were added in.
+	sorted  []*backend
+	table   []*backend
+	extract func(key string) string
+}
+
+// Option configures a Maglev.
+type Option func(m *Maglev)
+
+// WithKeyExtractor makes lookups hash extract(key) instead of key, so keys
+// sharing a hash tag land on the same backend.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(m *Maglev) {
+		m.extract = extract
+	}
+}
+
+type backend struct {
+	Backend
+	offset uint64
+	skip   uint64
+	// owned is the number of slots of the backend in the table.
+	owned uint64
+	// pos is scratch space for populate and fill.
+	pos uint64
+}
+
+// New returns a Maglev table of the given prime size over backends.
+func New(backends []Backend, size uint64, hash Hasher, opts ...Option) (*Maglev, error) {
+	if !isPrime(size) {
+		return nil, ErrInvalidSize
+	}
+	m := &Maglev{
+		size:     size,
+		hash:     hash,
+		backends: make(map[string]*backend, len(backends)),
+		table:    make([]*backend, size),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for _, b := range backends {
+		m.set(b)
+	}
+	m.sort()
+	m.populate()
+	return m, nil
+}
+
+// Size returns the table size.
+func (m *Maglev) Size() uint64 {
+	return m.size
+}
+
+// Lookup returns the backend name for key, or "" if there is no backend
+// with a positive weight.
+func (m *Maglev) Lookup(key string) string {
+	if len(m.sorted) == 0 {
+		return ""
+	}
+	if m.extract != nil {
+		key = m.extract(key)
+	}
+	b := m.table[m.hash(key)%m.size]
+	if b == nil {
+		return ""
+	}
+	return b.Name
+}
+
+// Backends returns the backends sorted by name.
+func (m *Maglev) Backends() []Backend {
+	res := make([]Backend, len(m.sorted))
+	for i, b := range m.sorted {
+		res[i] = b.Backend
+	}
+	return res
+}
+
+// Add adds b, or updates its weight if a backend with the same name exists.
+// The backend claims the slots it gains along its permutation, or gives
+// back those it loses to the other backends, so only keys moving to or from
+// it move.
+func (m *Maglev) Add(b Backend) {
+	m.set(b)
+	m.sort()
+	quotas := m.quotas()
+	changed := m.backends[b.Name]
+	if q := quotas[changed]; changed.owned < q {
+		m.claim(changed, q)
+	} else if changed.owned > q {
+		m.release(changed, q)
+	}
+	m.fill(quotas)
+}
+
+// Remove removes the backend named name, its slots are refilled by the
+// other backends. Removing an unknown backend is a no-op.
+func (m *Maglev) Remove(name string) {
+	b, ok := m.backends[name]
+	if !ok {
+		return
+	}
+	delete(m.backends, name)
+	m.sort()
+	m.release(b, 0)
+	m.fill(m.quotas())
+}
+
+// Rebuild refills the whole table as New does, so that the table only
+// depends on the backends and not on the order they were added and removed
+// in.
+func (m *Maglev) Rebuild() {
+	m.populate()
+}
+
+func (m *Maglev) set(b Backend) {
+	if old, ok := m.backends[b.Name]; ok {
+		old.Weight = b.Weight
+		return
+	}
+	// The skip is mixed from the hash so that offset and skip are
+	// independent.
+	h := m.hash(b.Name)
+	m.backends[b.Name] = &backend{
+		Backend: b,
+		offset:  h % m.size,
+		skip:    splitmix64.Mix(h)%(m.size-1) + 1,
+	}
+}
+
+// sort refreshes m.sorted from m.backends.
+func (m *Maglev) sort() {
+	m.sorted = m.sorted[:0]
+	for _, b := range m.backends {
+		m.sorted = append(m.sorted, b)
+	}
+	sort.Slice(m.sorted, func(i, j int) bool { return m.sorted[i].Name < m.sorted[j].Name })
+}
+
+// populate fills the table. In each round a backend of weight w claims
+// slots until it owns round*w/max(weight) of them, so with equal weights
+// this is the population algorithm of the paper.
+func (m *Maglev) populate() {
+	var maxWeight uint64
+	for _, b := range m.sorted {
+		if uint64(b.Weight) > maxWeight {
+			maxWeight = uint64(b.Weight)
+		}
+	}
+	for i := range m.table {
+		m.table[i] = nil
+	}
+	for _, b := range m.sorted {
+		b.pos = b.offset
+		b.owned = 0
+	}
+	if maxWeight == 0 {
+		return
+	}
+
+	var filled uint64
+	for round := uint64(1); ; round++ {
+		for _, b := range m.sorted {
+			for b.owned*maxWeight < round*uint64(b.Weight) {
+				for m.table[b.pos] != nil {
+					b.pos = (b.pos + b.skip) % m.size
+				}
+				m.table[b.pos] = b
+				b.pos = (b.pos + b.skip) % m.size
+				b.owned++
+				filled++
+				if filled == m.size {
+					return
+				}
+			}
+		}
+	}
+}
+
+// quotas returns the number of slots each backend should own, in proportion
+// to its weight. The slots left by rounding go to the largest remainders.
+func (m *Maglev) quotas() map[*backend]uint64 {
+	res := make(map[*backend]uint64, len(m.sorted))
+	var total uint64
+	for _, b := range m.sorted {
+		total += uint64(b.Weight)
+	}
+	if total == 0 {
+		return res
+	}
+	left := m.size
+	for _, b := range m.sorted {
+		res[b] = m.size * uint64(b.Weight) / total
+		left -= res[b]
+	}
+	byRemainder := make([]*backend, len(m.sorted))
+	copy(byRemainder, m.sorted)
+	sort.SliceStable(byRemainder, func(i, j int) bool {
+		return m.size*uint64(byRemainder[i].Weight)%total > m.size*uint64(byRemainder[j].Weight)%total
+	})
+	for _, b := range byRemainder[:left] {
+		res[b]++
+	}
+	return res
+}
+
+// claim makes b take the slots along its permutation until it owns quota
+// of them, whoever owned them.
+func (m *Maglev) claim(b *backend, quota uint64) {
+	for pos := b.offset; b.owned < quota; pos = (pos + b.skip) % m.size {
+		if other := m.table[pos]; other != b {
+			if other != nil {
+				other.owned--
+			}
+			m.table[pos] = b
+			b.owned++
+		}
+	}
+}
+
+// release frees the slots of b latest in its permutation until it owns
+// quota of them.
+func (m *Maglev) release(b *backend, quota uint64) {
+	var kept uint64
+	for pos := b.offset; b.owned > quota; pos = (pos + b.skip) % m.size {
+		if m.table[pos] != b {
+			continue
+		}
+		if kept < quota {
+			kept++
+		} else {
+			m.table[pos] = nil
+			b.owned--
+		}
+	}
+}
+
+// fill hands the free slots out to the backends below their quota, each
+// taking in turn the next free slot of its permutation as in populate.
+func (m *Maglev) fill(quotas map[*backend]uint64) {
+	var free uint64
+	for _, b := range m.table {
+		if b == nil {
+			free++
+		}
+	}
+	for _, b := range m.sorted {
+		b.pos = b.offset
+	}
+	for free > 0 {
+		claimed := false
+		for _, b := range m.sorted {
+			if free == 0 || b.owned >= quotas[b] {
+				continue
+			}
+			for m.table[b.pos] != nil {
+				b.pos = (b.pos + b.skip) % m.size
+			}
+			m.table[b.pos] = b
+			b.owned++
+			free--
+			claimed = true
+		}
+		if !claimed {
+			// No backend has a positive weight.
+			return
+		}
+	}
+}
+
+func isPrime(n uint64) bool {
+	if n < 2 {
+		return false
+	}
+	for i := uint64(2); i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package maglev
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getBackends(num int, weight uint) []Backend {
+	backends := make([]Backend, 0, num)
+	for i := 0; i < num; i++ {
+		backends = append(backends, Backend{fmt.Sprintf("127.0.0.1:800%d", i), weight})
+	}
+	return backends
+}
+
+func TestInvalidSize(t *testing.T) {
+	for _, size := range []uint64{0, 1, 4, 65536} {
+		if _, err := New(nil, size, hashString); err != ErrInvalidSize {
+			t.Errorf("size %d: expected ErrInvalidSize, got %v", size, err)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	m, _ := New(nil, 13, hashString)
+	if n := m.Lookup("hello"); n != "" {
+		t.Errorf("expected empty lookup, got %q", n)
+	}
+	m, _ = New([]Backend{{"a", 0}}, 13, hashString)
+	if n := m.Lookup("hello"); n != "" {
+		t.Errorf("expected empty lookup, got %q", n)
+	}
+}
+
+// slots returns how many table slots each backend owns.
+func slots(m *Maglev) map[string]int {
+	res := make(map[string]int)
+	for _, b := range m.table {
+		res[b.Name]++
+	}
+	return res
+}
+
+func TestBalance(t *testing.T) {
+	m, _ := New(getBackends(100, 1), DefaultSize, hashString)
+	avg := float64(DefaultSize) / 100
+	for name, n := range slots(m) {
+		if float64(n) < avg*0.99 || float64(n) > avg*1.01 {
+			t.Errorf("%s owns %d slots, expected about %.0f", name, n, avg)
+		}
+	}
+}
+
+func TestWeights(t *testing.T) {
+	backends := append(getBackends(3, 1), Backend{"heavy", 3})
+	m, _ := New(backends, DefaultSize, hashString)
+	s := slots(m)
+	for _, b := range backends[:3] {
+		if r := float64(s["heavy"]) / float64(s[b.Name]); r < 2.95 || r > 3.05 {
+			t.Errorf("expected heavy to own 3x the slots of %s, got %f", b.Name, r)
+		}
+	}
+}
+
+func TestOrderIndependent(t *testing.T) {
+	backends := getBackends(10, 1)
+	m1, _ := New(backends, 251, hashString)
+	reversed := make([]Backend, 0, len(backends))
+	m2, _ := New(nil, 251, hashString)
+	for i := len(backends) - 1; i >= 0; i-- {
+		reversed = append(reversed, backends[i])
+		m2.Add(backends[i])
+	}
+	m3, _ := New(reversed, 251, hashString)
+	// Adding one by one depends on the order until rebuilt.
+	m2.Rebuild()
+	for i := range m1.table {
+		if m1.table[i].Name != m2.table[i].Name || m1.table[i].Name != m3.table[i].Name {
+			t.Fatalf("slot %d differs", i)
+		}
+	}
+}
+
+func TestDisruption(t *testing.T) {
+	backends := getBackends(50, 1)
+	m, _ := New(backends, DefaultSize, hashString)
+	before := make([]string, 100000)
+	for i := range before {
+		before[i] = m.Lookup("key" + strconv.Itoa(i))
+	}
+
+	// Only the slots of the removed backend change hands.
+	removed := backends[7].Name
+	m.Remove(removed)
+	after := make([]string, len(before))
+	for i, n := range before {
+		after[i] = m.Lookup("key" + strconv.Itoa(i))
+		if n == removed && after[i] == n {
+			t.Fatalf("key%d still on removed backend", i)
+		}
+		if n != removed && after[i] != n {
+			t.Fatalf("key%d moved from %s to %s", i, n, after[i])
+		}
+	}
+
+	// Re-adding only moves keys to the added backend, which gets its
+	// share back.
+	m.Add(backends[7])
+	moved := 0
+	for i, n := range after {
+		if again := m.Lookup("key" + strconv.Itoa(i)); again != n {
+			if again != removed {
+				t.Fatalf("key%d moved from %s to %s after re-adding", i, n, again)
+			}
+			moved++
+		}
+	}
+	if r := float64(moved) / float64(len(before)); r < 0.018 || r > 0.022 {
+		t.Errorf("expected about 2%% of the keys to move to the re-added backend, got %.2f%%", r*100)
+	}
+	avg := float64(DefaultSize) / 50
+	for name, n := range slots(m) {
+		if float64(n) < avg*0.95 || float64(n) > avg*1.05 {
+			t.Errorf("%s owns %d slots, expected about %.0f", name, n, avg)
+		}
+	}
+
+	// Doubling the weight of a backend takes slots from the others.
+	m.Add(Backend{removed, 2})
+	s := slots(m)
+	if r := float64(s[removed]) / float64(s[backends[0].Name]); r < 1.9 || r > 2.1 {
+		t.Errorf("expected %s to own twice the slots of %s, got %f", removed, backends[0].Name, r)
+	}
+	m.Add(Backend{removed, 0})
+	if s := slots(m); s[removed] != 0 || len(s) != 49 {
+		t.Errorf("expected a backend of weight 0 to own no slots, got %v", s[removed])
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	prefix := func(key string) string { return key[:4] }
+	m, _ := New(getBackends(10, 1), 251, hashString, WithKeyExtractor(prefix))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i%100)
+		if m.Lookup(key+"profile") != m.Lookup(key+"cart") {
+			t.Errorf("keys with prefix %s are on different nodes", key)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	m, _ := New(getBackends(100, 1), DefaultSize, hashString)
+	for i := 0; i < b.N; i++ {
+		m.Lookup("Lorem ipsum dolor sit amet")
+	}
+}
+
+func BenchmarkPopulate(b *testing.B) {
+	m, _ := New(getBackends(100, 1), DefaultSize, hashString)
+	for i := 0; i < b.N; i++ {
+		m.populate()
+	}
+}
@@ -0,0 +1,148 @@
+// Package multiprobe implements multi-probe consistent hashing[1]. Each node
+// has a single point on the ring and each key is hashed probes times; the
+// key goes to the node whose point follows one of the probes most closely.
+// With 21 probes the peak-to-average load is about 1.05, which ketama needs
+// hundreds of points per node to reach.
+//
+// [1] https://arxiv.org/pdf/1505.00062.pdf
+package multiprobe
+
+import (
+	"sort"
+
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// DefaultProbes gives a peak-to-average load of about 1.05.
+const DefaultProbes = 21
+
+// Hasher hashes a key or a node name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Multiprobe is a multi-probe consistent hashing ring.
+// It is not safe for concurrent use.
+type Multiprobe struct {
+	probes  int
+	hash    Hasher
+	points  []point
+	extract func(key string) string
+}
+
+// Option configures a Multiprobe.
+type Option func(m *Multiprobe)
+
+// WithKeyExtractor makes lookups hash extract(key) instead of key, so keys
+// sharing a hash tag land on the same node.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(m *Multiprobe) {
+		m.extract = extract
+	}
+}
+
+type point struct {
+	hash uint32
+	node string
+}
+
+// New returns a Multiprobe over nodes. probes less than 1 means 1.
+func New(nodes []string, probes int, hash Hasher, opts ...Option) *Multiprobe {
+	if probes < 1 {
+		probes = 1
+	}
+	m := &Multiprobe{
+		probes: probes,
+		hash:   hash,
+		points: make([]point, 0, len(nodes)),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for _, node := range nodes {
+		if m.index(node) < 0 {
+			m.points = append(m.points, point{nodeHash(hash, node), node})
+		}
+	}
+	m.sort()
+	return m
+}
+
+// Lookup returns the node for key, or "" if there are no nodes.
+func (m *Multiprobe) Lookup(key string) string {
+	if len(m.points) == 0 {
+		return ""
+	}
+	if m.extract != nil {
+		key = m.extract(key)
+	}
+	khash := m.hash(key)
+	var best int
+	var bestDistance uint32
+	for i := 0; i < m.probes; i++ {
+		h := fold(splitmix64.Mix(khash + uint64(i)*splitmix64.Gamma))
+		j := ketama.Search(len(m.points), m.hashAt, h)
+		// Unsigned arithmetic takes care of the wraparound.
+		if d := m.points[j].hash - h; i == 0 || d < bestDistance {
+			best, bestDistance = j, d
+		}
+	}
+	return m.points[best].node
+}
+
+// Nodes returns the nodes in ring order.
+func (m *Multiprobe) Nodes() []string {
+	res := make([]string, len(m.points))
+	for i, p := range m.points {
+		res[i] = p.node
+	}
+	return res
+}
+
+// Add adds node. Adding an existing node is a no-op.
+func (m *Multiprobe) Add(node string) {
+	if m.index(node) >= 0 {
+		return
+	}
+	m.points = append(m.points, point{nodeHash(m.hash, node), node})
+	m.sort()
+}
+
+// Remove removes node. Removing an unknown node is a no-op.
+func (m *Multiprobe) Remove(node string) {
+	if i := m.index(node); i >= 0 {
+		m.points = append(m.points[:i], m.points[i+1:]...)
+	}
+}
+
+func (m *Multiprobe) index(node string) int {
+	for i, p := range m.points {
+		if p.node == node {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Multiprobe) hashAt(i int) uint32 {
+	return m.points[i].hash
+}
+
+func (m *Multiprobe) sort() {
+	sort.Slice(m.points, func(i, j int) bool {
+		if m.points[i].hash != m.points[j].hash {
+			return m.points[i].hash < m.points[j].hash
+		}
+		return m.points[i].node < m.points[j].node
+	})
+}
+
+// nodeHash returns the ring point of node. The hash is mixed like the
+// probes, weak hashes such as FNV cluster similar names otherwise.
+func nodeHash(hash Hasher, node string) uint32 {
+	return fold(splitmix64.Mix(hash(node)))
+}
+
+// fold folds a 64 bit hash into the 32 bit ring.
+func fold(h uint64) uint32 {
+	return uint32(h ^ (h >> 32))
+}
@@ -0,0 +1,105 @@
+package multiprobe
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getServerNodes(nodenum int) []string {
+	nodes := make([]string, 0, nodenum)
+	for i := 0; i < nodenum; i++ {
+		nodes = append(nodes, fmt.Sprintf("127.0.0.1:800%d", i))
+	}
+	return nodes
+}
+
+// peakToAverage returns the max load over the average load.
+func peakToAverage(m *Multiprobe, testCount int) float64 {
+	count := make(map[string]int)
+	peak := 0
+	for i := 0; i < testCount; i++ {
+		node := m.Lookup("testName" + strconv.Itoa(i))
+		count[node]++
+		if count[node] > peak {
+			peak = count[node]
+		}
+	}
+	return float64(peak) / (float64(testCount) / float64(len(m.points)))
+}
+
+func TestEmpty(t *testing.T) {
+	m := New(nil, DefaultProbes, hashString)
+	if n := m.Lookup("hello"); n != "" {
+		t.Errorf("expected empty lookup, got %q", n)
+	}
+}
+
+func TestPeakToAverage(t *testing.T) {
+	nodes := getServerNodes(100)
+	testCount := 1000000
+	var last float64
+	for _, probes := range []int{1, 5, 21} {
+		r := peakToAverage(New(nodes, probes, hashString), testCount)
+		fmt.Printf("####测试%d个结点,%d个探测,%d条测试数据, 峰均比:%f\n", len(nodes), probes, testCount, r)
+		if last != 0 && r >= last {
+			t.Errorf("%d probes: peak-to-average %f not below %f", probes, r, last)
+		}
+		last = r
+	}
+	if last > 1.15 {
+		t.Errorf("expected peak-to-average close to 1.05 with %d probes, got %f", DefaultProbes, last)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	nodes := getServerNodes(20)
+	m := New(nodes, DefaultProbes, hashString)
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = m.Lookup(key)
+	}
+	m.Add("127.0.0.1:9000")
+	m.Add("127.0.0.1:9000")
+	if len(m.Nodes()) != 21 {
+		t.Fatalf("expected 21 nodes, got %v", m.Nodes())
+	}
+	for key, n := range before {
+		if after := m.Lookup(key); after != n && after != "127.0.0.1:9000" {
+			t.Errorf("key %s moved from %s to %s", key, n, after)
+		}
+	}
+	m.Remove("127.0.0.1:9000")
+	m.Remove(nodes[5])
+	for key, n := range before {
+		if after := m.Lookup(key); n != nodes[5] && after != n || after == nodes[5] {
+			t.Errorf("key %s moved from %s to %s", key, n, after)
+		}
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	prefix := func(key string) string { return key[:4] }
+	m := New(getServerNodes(10), DefaultProbes, hashString, WithKeyExtractor(prefix))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i%100)
+		if m.Lookup(key+"profile") != m.Lookup(key+"cart") {
+			t.Errorf("keys with prefix %s are on different nodes", key)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	m := New(getServerNodes(1000), DefaultProbes, hashString)
+	for i := 0; i < b.N; i++ {
+		m.Lookup("Lorem ipsum dolor sit amet")
+	}
+}
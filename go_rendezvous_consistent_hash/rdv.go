@@ -1,45 +1,108 @@
 package rendezvous
 
+import (
+	"errors"
+	"sort"
+
+	siphash "github.com/shanyux/consistent_hash/go_siphash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// ErrNodeNotFound is returned when marking a node that is not in the
+// Rendezvous.
+var ErrNodeNotFound = errors.New("rendezvous: node not found")
+
+// Rendezvous implements rendezvous (highest random weight) hashing.
+// It is not safe for concurrent use.
 type Rendezvous struct {
 	nodes         map[string]int
 	nodeStr       []string
 	nodeHashValue []uint64
 	hash          Hasher
+	extract       func(key string) string
+	seed          uint64
+	down          map[string]bool
+}
+
+// Option configures a Rendezvous.
+type Option func(r *Rendezvous)
+
+// WithKeyExtractor makes lookups hash extract(key) instead of key, so keys
+// sharing a hash tag land on the same node.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(r *Rendezvous) {
+		r.extract = extract
+	}
 }
 
+// Hasher hashes a key or a node name to a 64 bit value.
 type Hasher func(s string) uint64
 
-func NewRendezvous(nodes []string, hash Hasher) *Rendezvous {
+// WithSeed salts the hashing of nodes and keys with seed, so instances with
+// different seeds place keys independently of each other. Seed 0 is the
+// unsalted hashing.
+func WithSeed(seed uint64) Option {
+	return func(r *Rendezvous) {
+		r.seed = seed
+	}
+}
+
+// WithSipHashKey replaces hash with SipHash-2-4 under the secret key, so
+// clients that do not know the key cannot craft keys that all land on the
+// same node.
+func WithSipHashKey(key [siphash.KeySize]byte) Option {
+	return func(r *Rendezvous) {
+		r.hash = siphash.StringHasher(key)
+	}
+}
+
+// NewRendezvous returns a Rendezvous over the given nodes.
+func NewRendezvous(nodes []string, hash Hasher, opts ...Option) *Rendezvous {
 	r := &Rendezvous{
 		nodes:         make(map[string]int, len(nodes)),
 		nodeStr:       make([]string, len(nodes)),
 		nodeHashValue: make([]uint64, len(nodes)),
 		hash:          hash,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
 
 	for i, n := range nodes {
 		r.nodes[n] = i
 		r.nodeStr[i] = n
-		r.nodeHashValue[i] = hash(n)
+		r.nodeHashValue[i] = r.nodeHash(n)
 	}
 
 	return r
 }
 
 // Lookup 查找 key 匹配的 node
+// 没有 node 时返回空字符串
 func (r *Rendezvous) Lookup(k string) string {
+	if len(r.nodeStr) == 0 {
+		return ""
+	}
+	if len(r.down) > 0 {
+		// 跳过 down 的 node，取分数次高的
+		if res := r.LookupN(k, 1); len(res) > 0 {
+			return res[0]
+		}
+		return ""
+	}
+
 	// 首先计算 hash(key)
-	khash := r.hash(k)
+	khash := r.keyHash(k)
 
 	// 先计算 keyHash 和 nodeHash[0] 的 hash 作为初始值
 	var midx int
-	var mhash = xorshiftMult64(khash ^ r.nodeHashValue[0])
+	var mhash = Score(khash, r.nodeHashValue[0])
 
 	// 遍历所有的 nodeHash，计算 hash(keyHash + nodeHash)
 	// 寻找计算结果最大的 node 的 idx
 	// 这里，已经预先算好的每一个 nodeHash，存储顺序和 nodes 列表一致
 	for i, nodeHashValue := range r.nodeHashValue[1:] {
-		if h := xorshiftMult64(khash ^ nodeHashValue); h > mhash {
+		if h := Score(khash, nodeHashValue); h > mhash {
 			midx = i + 1
 			mhash = h
 		}
@@ -49,18 +112,72 @@ func (r *Rendezvous) Lookup(k string) string {
 	return r.nodeStr[midx]
 }
 
+// LookupN 按 hash(keyHash + nodeHash) 从大到小返回最多 n 个 node，
+// 第一个总是与 Lookup 的结果相同，down 的 node 被跳过
+func (r *Rendezvous) LookupN(k string, n int) []string {
+	if n > len(r.nodeStr)-len(r.down) {
+		n = len(r.nodeStr) - len(r.down)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	khash := r.keyHash(k)
+	scores := make([]uint64, len(r.nodeHashValue))
+	idx := make([]int, len(r.nodeHashValue))
+	for i, nodeHashValue := range r.nodeHashValue {
+		scores[i] = Score(khash, nodeHashValue)
+		idx[i] = i
+	}
+	// 分数相同时保持 Lookup 的选择，即下标小的优先
+	sort.SliceStable(idx, func(a, b int) bool {
+		return scores[idx[a]] > scores[idx[b]]
+	})
+
+	res := make([]string, 0, n)
+	for _, i := range idx {
+		if len(res) == n {
+			break
+		}
+		if !r.down[r.nodeStr[i]] {
+			res = append(res, r.nodeStr[i])
+		}
+	}
+	return res
+}
+
+// Nodes returns the current nodes in insertion order.
+func (r *Rendezvous) Nodes() []string {
+	res := make([]string, len(r.nodeStr))
+	copy(res, r.nodeStr)
+	return res
+}
+
+// Len returns the number of nodes.
+func (r *Rendezvous) Len() int {
+	return len(r.nodeStr)
+}
+
+// Add adds node. Adding an existing node is a no-op.
 func (r *Rendezvous) Add(node string) {
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
 	r.nodes[node] = len(r.nodeStr)
 	r.nodeStr = append(r.nodeStr, node)
-	r.nodeHashValue = append(r.nodeHashValue, r.hash(node))
+	r.nodeHashValue = append(r.nodeHashValue, r.nodeHash(node))
 }
 
+// Remove removes node. Removing an unknown node is a no-op.
 func (r *Rendezvous) Remove(node string) {
 	// find index of node to remove
-	nidx := r.nodes[node]
+	nidx, ok := r.nodes[node]
+	if !ok {
+		return
+	}
 
 	// remove from the slices
-	l := len(r.nodeStr)
+	l := len(r.nodeStr) - 1
 	r.nodeStr[nidx] = r.nodeStr[l]
 	r.nodeStr = r.nodeStr[:l]
 
@@ -69,8 +186,59 @@ func (r *Rendezvous) Remove(node string) {
 
 	// update the map
 	delete(r.nodes, node)
-	moved := r.nodeStr[nidx]
-	r.nodes[moved] = nidx
+	delete(r.down, node)
+	if nidx < l {
+		moved := r.nodeStr[nidx]
+		r.nodes[moved] = nidx
+	}
+}
+
+// MarkDown marks node as down. Lookups skip it and use the node with the
+// next highest score instead, but unlike Remove the keys of the other nodes
+// do not move and its own keys come back once it is marked up.
+func (r *Rendezvous) MarkDown(node string) error {
+	if _, ok := r.nodes[node]; !ok {
+		return ErrNodeNotFound
+	}
+	if r.down == nil {
+		r.down = make(map[string]bool)
+	}
+	r.down[node] = true
+	return nil
+}
+
+// MarkUp marks node as up again.
+func (r *Rendezvous) MarkUp(node string) error {
+	if _, ok := r.nodes[node]; !ok {
+		return ErrNodeNotFound
+	}
+	delete(r.down, node)
+	return nil
+}
+
+// IsDown reports whether node is marked down.
+func (r *Rendezvous) IsDown(node string) bool {
+	return r.down[node]
+}
+
+// keyHash returns the hash of key.
+func (r *Rendezvous) keyHash(k string) uint64 {
+	if r.extract != nil {
+		k = r.extract(k)
+	}
+	return splitmix64.Seed(r.hash(k), r.seed)
+}
+
+// nodeHash returns the hash of node. The salting mixes the seed into the
+// hash, so it does not cancel out with the one of the key in Score.
+func (r *Rendezvous) nodeHash(node string) uint64 {
+	return splitmix64.Seed(r.hash(node), r.seed)
+}
+
+// Score returns the score of the node hashed to nodeHash for the key hashed
+// to keyHash. A key goes to the node with the highest score.
+func Score(keyHash, nodeHash uint64) uint64 {
+	return xorshiftMult64(keyHash ^ nodeHash)
 }
 
 //https://vigna.di.unimi.it/ftp/papers/xorshift.pdf
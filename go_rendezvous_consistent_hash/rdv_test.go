@@ -22,8 +22,193 @@ func hashString(s string) uint64 {
 
 func TestEmpty(t *testing.T) {
 	r := NewRendezvous([]string{}, hashString)
-	r.Lookup("hello")
+	if n := r.Lookup("hello"); n != "" {
+		t.Errorf("expected empty lookup, got %q", n)
+	}
+	if ns := r.LookupN("hello", 2); len(ns) != 0 {
+		t.Errorf("expected no nodes, got %v", ns)
+	}
+}
+
+func TestLookupN(t *testing.T) {
+	r := NewRendezvous(getServerNodes(8), hashString)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		ns := r.LookupN(key, 3)
+		if len(ns) != 3 || ns[0] != r.Lookup(key) {
+			t.Fatalf("LookupN(%s) = %v, Lookup = %s", key, ns, r.Lookup(key))
+		}
+		// The second choice is the winner once the first one is gone.
+		r.Remove(ns[0])
+		if n := r.Lookup(key); n != ns[1] {
+			t.Errorf("expected %s after removing %s, got %s", ns[1], ns[0], n)
+		}
+		r.Add(ns[0])
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	nodes := getServerNodes(8)
+	r := NewRendezvous(nodes, hashString)
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = r.Lookup(key)
+	}
+	r.Remove(nodes[7])
+	r.Remove(nodes[2])
+	r.Remove("unknown")
+	if len(r.Nodes()) != 6 {
+		t.Fatalf("expected 6 nodes, got %v", r.Nodes())
+	}
+	for key, n := range before {
+		if got := r.Lookup(key); n != nodes[7] && n != nodes[2] && got != n {
+			t.Errorf("key %s moved from %s to %s", key, n, got)
+		}
+	}
+	r.Add(nodes[2])
+	r.Add(nodes[7])
+	r.Add(nodes[7])
+	for key, n := range before {
+		if got := r.Lookup(key); got != n {
+			t.Errorf("key %s moved from %s to %s", key, n, got)
+		}
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	prefix := func(key string) string { return key[:4] }
+	r := NewRendezvous(getServerNodes(8), hashString, WithKeyExtractor(prefix))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i%100)
+		if n := r.Lookup(key + "profile"); n != r.Lookup(key+"cart") || n != r.LookupN(key, 2)[0] {
+			t.Errorf("keys with prefix %s are on different nodes", key)
+		}
+	}
+}
 
+// seedTestVectors were computed before seeds existed.
+var seedTestVectors = []struct {
+	key  string
+	node string
+}{
+	{"key1", "127.0.0.1:8000"},
+	{"key2", "127.0.0.1:8002"},
+	{"key3", "127.0.0.1:8002"},
+	{"key4", "127.0.0.1:8000"},
+	{"hello", "127.0.0.1:8001"},
+	{"world", "127.0.0.1:8003"},
+}
+
+func TestSeed(t *testing.T) {
+	nodes := getServerNodes(5)
+	for _, r := range []*Rendezvous{NewRendezvous(nodes, hashString), NewRendezvous(nodes, hashString, WithSeed(0))} {
+		for _, v := range seedTestVectors {
+			if n := r.Lookup(v.key); n != v.node {
+				t.Errorf("expected %s on %s, got %s", v.key, v.node, n)
+			}
+		}
+	}
+
+	// Differently seeded instances agree on about 1/len(nodes) of the keys.
+	nodes = getServerNodes(10)
+	r1 := NewRendezvous(nodes, hashString, WithSeed(1))
+	r2 := NewRendezvous(nodes, hashString, WithSeed(2))
+	same := 0
+	testCount := 20000
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if r1.Lookup(key) == r2.Lookup(key) {
+			same++
+		}
+	}
+	if r := float64(same) / float64(testCount); r < 0.07 || r > 0.13 {
+		t.Errorf("seeded instances agree on %.3f of the keys, expected about 0.1", r)
+	}
+}
+
+func TestSipHashKey(t *testing.T) {
+	nodes := getServerNodes(10)
+	plain := NewRendezvous(nodes, hashString)
+	keyed := NewRendezvous(nodes, hashString, WithSipHashKey([16]byte{1}))
+	again := NewRendezvous(nodes, nil, WithSipHashKey([16]byte{1}))
+	other := NewRendezvous(nodes, hashString, WithSipHashKey([16]byte{2}))
+	unkeyed, same := 0, 0
+	testCount := 20000
+	for i := 0; i < testCount; i++ {
+		key := strconv.Itoa(i)
+		if keyed.Lookup(key) != again.Lookup(key) {
+			t.Fatalf("instances with the same key disagree on %s", key)
+		}
+		if plain.Lookup(key) == keyed.Lookup(key) {
+			unkeyed++
+		}
+		if other.Lookup(key) == keyed.Lookup(key) {
+			same++
+		}
+	}
+	for _, n := range []int{unkeyed, same} {
+		if r := float64(n) / float64(testCount); r < 0.07 || r > 0.13 {
+			t.Errorf("keyed instances agree on %.3f of the keys, expected about 0.1", r)
+		}
+	}
+}
+
+func TestMarkDown(t *testing.T) {
+	nodes := getServerNodes(10)
+	r := NewRendezvous(nodes, hashString)
+	testCount := 10000
+	before := make([]string, testCount)
+	for i := range before {
+		before[i] = r.Lookup(strconv.Itoa(i))
+	}
+	down := nodes[3]
+	if err := r.MarkDown(down); err != nil {
+		t.Fatal(err)
+	}
+	if r.MarkDown("unknown") != ErrNodeNotFound || r.MarkUp("unknown") != ErrNodeNotFound {
+		t.Error("expected ErrNodeNotFound for an unknown node")
+	}
+	if !r.IsDown(down) || r.IsDown("unknown") {
+		t.Fatal("expected only the known node to be down")
+	}
+	for i := range before {
+		key := strconv.Itoa(i)
+		node := r.Lookup(key)
+		if before[i] == down {
+			// The keys of the down node go to the node with the next
+			// highest score.
+			r.MarkUp(down)
+			next := r.LookupN(key, 2)[1]
+			r.MarkDown(down)
+			if node != next {
+				t.Fatalf("expected %s on %s, got %s", key, next, node)
+			}
+		} else if node != before[i] {
+			t.Fatalf("healthy node's key %s moved from %s to %s", key, before[i], node)
+		}
+		if ns := r.LookupN(key, 10); len(ns) != 9 || ns[0] != node {
+			t.Fatalf("expected the 9 up nodes starting with %s, got %v", node, ns)
+		}
+	}
+	r.MarkUp(down)
+	for i := range before {
+		if node := r.Lookup(strconv.Itoa(i)); node != before[i] {
+			t.Fatalf("expected %d back on %s, got %s", i, before[i], node)
+		}
+	}
+
+	for _, n := range nodes {
+		r.MarkDown(n)
+	}
+	if r.Lookup("key") != "" || r.LookupN("key", 3) != nil {
+		t.Error("expected no node when all nodes are down")
+	}
+	r.Remove(nodes[0])
+	r.Add(nodes[0])
+	if r.Lookup("key") != nodes[0] {
+		t.Error("expected a re-added node to be up")
+	}
 }
 
 func getServerNodes(nodenum int) []string {
@@ -0,0 +1,178 @@
+// Package shufflesharding maps each tenant to a small shard of nodes with
+// rendezvous hashing, so that a tenant overloading or poisoning its shard
+// only affects the few tenants sharing all of its nodes. With n nodes and
+// shards of k nodes there are C(n, k) possible shards, so two tenants
+// rarely get the same one.
+package shufflesharding
+
+import (
+	"sort"
+
+	rendezvous "github.com/shanyux/consistent_hash/go_rendezvous_consistent_hash"
+)
+
+// Hasher hashes a tenant or a node name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Node is a node in a zone. Shards are spread evenly over the zones.
+type Node struct {
+	Name string
+	Zone string
+}
+
+// Sharder assigns shards to tenants.
+// It is not safe for concurrent use.
+type Sharder struct {
+	size   int
+	hash   Hasher
+	nodes  []Node
+	hashes []uint64
+	index  map[string]int
+}
+
+// New returns a Sharder giving each tenant shardSize of nodes.
+func New(nodes []string, shardSize int, hash Hasher) *Sharder {
+	zoned := make([]Node, len(nodes))
+	for i, n := range nodes {
+		zoned[i] = Node{Name: n}
+	}
+	return NewZoned(zoned, shardSize, hash)
+}
+
+// NewZoned returns a Sharder giving each tenant shardSize of nodes, taken
+// evenly from their zones.
+func NewZoned(nodes []Node, shardSize int, hash Hasher) *Sharder {
+	s := &Sharder{size: shardSize, hash: hash, index: make(map[string]int, len(nodes))}
+	for _, n := range nodes {
+		s.Add(n)
+	}
+	return s
+}
+
+// Add adds node. Only the shards the node scores into change, each of them
+// by one node as long as the shard size is a multiple of the number of
+// zones. Adding an existing node is a no-op.
+func (s *Sharder) Add(node Node) {
+	if _, ok := s.index[node.Name]; ok {
+		return
+	}
+	s.index[node.Name] = len(s.nodes)
+	s.nodes = append(s.nodes, node)
+	s.hashes = append(s.hashes, s.hash(node.Name))
+}
+
+// Remove removes the node named name. Only the shards holding it change.
+// Removing an unknown node is a no-op.
+func (s *Sharder) Remove(name string) {
+	i, ok := s.index[name]
+	if !ok {
+		return
+	}
+	last := len(s.nodes) - 1
+	s.nodes[i], s.hashes[i] = s.nodes[last], s.hashes[last]
+	s.index[s.nodes[i].Name] = i
+	s.nodes, s.hashes = s.nodes[:last], s.hashes[:last]
+	delete(s.index, name)
+}
+
+// Shard returns the shard of tenant, up to shardSize nodes in decreasing
+// score order. Within each zone the nodes with the highest
+// rendezvous.Score are taken, one zone after the other so that the zones
+// differ by at most one node unless a zone runs out of nodes.
+func (s *Sharder) Shard(tenant string) []string {
+	n := s.size
+	if n > len(s.nodes) {
+		n = len(s.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	type scored struct {
+		name  string
+		score uint64
+	}
+	tenantHash := s.hash(tenant)
+	byZone := make(map[string][]scored)
+	for i, node := range s.nodes {
+		byZone[node.Zone] = append(byZone[node.Zone], scored{node.Name, rendezvous.Score(tenantHash, s.hashes[i])})
+	}
+	zones := make([][]scored, 0, len(byZone))
+	for _, nodes := range byZone {
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].score != nodes[j].score {
+				return nodes[i].score > nodes[j].score
+			}
+			return nodes[i].name < nodes[j].name
+		})
+		zones = append(zones, nodes)
+	}
+	// The zone of the best node goes first, so which zones get an extra
+	// node varies with the tenant.
+	sort.Slice(zones, func(i, j int) bool {
+		if zones[i][0].score != zones[j][0].score {
+			return zones[i][0].score > zones[j][0].score
+		}
+		return zones[i][0].name < zones[j][0].name
+	})
+
+	res := make([]string, 0, n)
+	for round := 0; len(res) < n; round++ {
+		for _, nodes := range zones {
+			if round < len(nodes) && len(res) < n {
+				res = append(res, nodes[round].name)
+			}
+		}
+	}
+	return res
+}
+
+// Stats describes how much the shards of pairs of tenants overlap.
+type Stats struct {
+	// Pairs is the number of pairs of tenants.
+	Pairs int
+	// Histogram[i] is the number of pairs sharing i nodes.
+	Histogram []int
+	// Full is the number of pairs sharing all the nodes of the smaller
+	// shard.
+	Full int
+	// Mean is the average number of nodes a pair shares.
+	Mean float64
+}
+
+// OverlapStats returns the overlap statistics of shards.
+func OverlapStats(shards [][]string) Stats {
+	var st Stats
+	var total int
+	for i := range shards {
+		in := make(map[string]bool, len(shards[i]))
+		for _, node := range shards[i] {
+			in[node] = true
+		}
+		for j := i + 1; j < len(shards); j++ {
+			shared := 0
+			for _, node := range shards[j] {
+				if in[node] {
+					shared++
+				}
+			}
+			for len(st.Histogram) <= shared {
+				st.Histogram = append(st.Histogram, 0)
+			}
+			st.Histogram[shared]++
+			st.Pairs++
+			total += shared
+			smaller := len(shards[i])
+			if len(shards[j]) < smaller {
+				smaller = len(shards[j])
+			}
+			if shared == smaller {
+				st.Full++
+			}
+		}
+	}
+	if st.Pairs > 0 {
+		st.Mean = float64(total) / float64(st.Pairs)
+	}
+	return st
+}
@@ -0,0 +1,164 @@
+package shufflesharding
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getServerNodes(nodenum int) []string {
+	nodes := make([]string, 0, nodenum)
+	for i := 0; i < nodenum; i++ {
+		nodes = append(nodes, fmt.Sprintf("127.0.0.1:80%02d", i))
+	}
+	return nodes
+}
+
+func shards(s *Sharder, tenants int) [][]string {
+	res := make([][]string, tenants)
+	for i := range res {
+		res[i] = s.Shard("tenant" + strconv.Itoa(i))
+	}
+	return res
+}
+
+// changed returns how many nodes of b are not in a.
+func changed(a, b []string) int {
+	in := make(map[string]bool, len(a))
+	for _, node := range a {
+		in[node] = true
+	}
+	n := 0
+	for _, node := range b {
+		if !in[node] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestShard(t *testing.T) {
+	s := New(getServerNodes(50), 5, hashString)
+	for i, shard := range shards(s, 1000) {
+		if len(shard) != 5 || changed(shard, s.Shard("tenant"+strconv.Itoa(i))) != 0 {
+			t.Fatalf("expected a stable shard of 5 nodes, got %v", shard)
+		}
+		seen := make(map[string]bool)
+		for _, node := range shard {
+			if seen[node] {
+				t.Fatalf("duplicate node in %v", shard)
+			}
+			seen[node] = true
+		}
+	}
+	if shard := New(getServerNodes(3), 5, hashString).Shard("tenant"); len(shard) != 3 {
+		t.Errorf("expected all 3 nodes, got %v", shard)
+	}
+	if shard := New(nil, 5, hashString).Shard("tenant"); shard != nil {
+		t.Errorf("expected no shard, got %v", shard)
+	}
+}
+
+func TestOverlapStats(t *testing.T) {
+	st := OverlapStats([][]string{{"a", "b"}, {"b", "c"}, {"b", "a"}, {"d"}})
+	if st.Pairs != 6 || st.Full != 1 || st.Histogram[0] != 3 || st.Histogram[1] != 2 || st.Histogram[2] != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if st.Mean != 4.0/6 {
+		t.Errorf("expected a mean overlap of 4/6, got %f", st.Mean)
+	}
+
+	// 50 nodes give C(50, 5) = 2118760 shards of 5 nodes: among the
+	// 499500 pairs of 1000 tenants about 0.24 share a whole shard, and a
+	// pair shares 5*5/50 = 0.5 nodes on average.
+	st = OverlapStats(shards(New(getServerNodes(50), 5, hashString), 1000))
+	fmt.Printf("overlap histogram %v, full overlaps %d, mean %f\n", st.Histogram, st.Full, st.Mean)
+	if st.Full > 2 || st.Mean < 0.45 || st.Mean > 0.55 {
+		t.Errorf("unexpected overlap stats %+v", st)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	nodes := getServerNodes(50)
+	s := New(nodes, 5, hashString)
+	before := shards(s, 10000)
+
+	s.Add(Node{Name: "127.0.0.1:9000"})
+	s.Add(Node{Name: "127.0.0.1:9000"})
+	moved := 0
+	for i, shard := range shards(s, 10000) {
+		switch changed(before[i], shard) {
+		case 0:
+		case 1:
+			moved++
+		default:
+			t.Fatalf("adding a node changed %v to %v", before[i], shard)
+		}
+	}
+	// The new node enters about 5/51 of the shards.
+	if f := float64(moved) / 10000; f < 0.08 || f > 0.12 {
+		t.Errorf("adding a node changed %f of the shards", f)
+	}
+
+	s.Remove("127.0.0.1:9000")
+	s.Remove("unknown")
+	after := shards(s, 10000)
+	for i := range before {
+		if changed(before[i], after[i]) != 0 {
+			t.Fatalf("removing the added node must restore %v, got %v", before[i], after[i])
+		}
+	}
+	s.Remove(nodes[7])
+	for i, shard := range shards(s, 10000) {
+		holds := false
+		for _, node := range before[i] {
+			holds = holds || node == nodes[7]
+		}
+		if c := changed(before[i], shard); holds && c != 1 || !holds && c != 0 {
+			t.Fatalf("removing %s changed %v to %v", nodes[7], before[i], shard)
+		}
+	}
+}
+
+func TestZoned(t *testing.T) {
+	// Uneven zones: a holds 10 nodes, b 6 and c 4.
+	var nodes []Node
+	zoneOf := make(map[string]string)
+	for i, name := range getServerNodes(20) {
+		zone := "a"
+		if i >= 16 {
+			zone = "c"
+		} else if i >= 10 {
+			zone = "b"
+		}
+		nodes = append(nodes, Node{Name: name, Zone: zone})
+		zoneOf[name] = zone
+	}
+	for _, v := range []struct {
+		size     int
+		min, max int
+	}{{6, 2, 2}, {7, 2, 3}, {15, 4, 6}} {
+		s := NewZoned(nodes, v.size, hashString)
+		for i, shard := range shards(s, 1000) {
+			count := make(map[string]int)
+			for _, node := range shard {
+				count[zoneOf[node]]++
+			}
+			if len(shard) != v.size || len(count) != 3 {
+				t.Fatalf("expected %d nodes in 3 zones, got %v", v.size, shard)
+			}
+			for _, c := range count {
+				if c < v.min || c > v.max {
+					t.Fatalf("tenant%d: expected %d to %d nodes per zone, got %v", i, v.min, v.max, count)
+				}
+			}
+		}
+	}
+}
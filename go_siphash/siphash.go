@@ -0,0 +1,132 @@
+// Package siphash implements SipHash-2-4[1], a keyed hash function. Without
+// the key an attacker cannot predict the hash of a key, so keys cannot be
+// crafted to all land on the same node.
+//
+// [1] https://cr.yp.to/siphash/siphash-20120918.pdf
+package siphash
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// KeySize is the size of a SipHash key in bytes.
+const KeySize = 16
+
+// Sum64 returns the 64 bit SipHash-2-4 of p under key.
+func Sum64(key *[KeySize]byte, p []byte) uint64 {
+	v0, v1, v2, v3 := initState(key)
+	v0, v1, v2, v3 = compress(v0, v1, v2, v3, p)
+	v2 ^= 0xff
+	v0, v1, v2, v3 = rounds(v0, v1, v2, v3, 4)
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// Sum128 returns the 128 bit SipHash-2-4 of p under key.
+func Sum128(key *[KeySize]byte, p []byte) [16]byte {
+	v0, v1, v2, v3 := initState(key)
+	v1 ^= 0xee
+	v0, v1, v2, v3 = compress(v0, v1, v2, v3, p)
+	var out [16]byte
+	v2 ^= 0xee
+	v0, v1, v2, v3 = rounds(v0, v1, v2, v3, 4)
+	binary.LittleEndian.PutUint64(out[:8], v0^v1^v2^v3)
+	v1 ^= 0xdd
+	v0, v1, v2, v3 = rounds(v0, v1, v2, v3, 4)
+	binary.LittleEndian.PutUint64(out[8:], v0^v1^v2^v3)
+	return out
+}
+
+// StringHasher returns a function hashing strings with Sum64 under key.
+func StringHasher(key [KeySize]byte) func(s string) uint64 {
+	return func(s string) uint64 {
+		return Sum64(&key, []byte(s))
+	}
+}
+
+func initState(key *[KeySize]byte) (v0, v1, v2, v3 uint64) {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	return k0 ^ 0x736f6d6570736575, k1 ^ 0x646f72616e646f6d,
+		k0 ^ 0x6c7967656e657261, k1 ^ 0x7465646279746573
+}
+
+// compress absorbs p, including the final block holding its length.
+func compress(v0, v1, v2, v3 uint64, p []byte) (uint64, uint64, uint64, uint64) {
+	n := len(p)
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		v0, v1, v2, v3 = rounds(v0, v1, v2, v3, 2)
+		v0 ^= m
+	}
+	b := uint64(n) << 56
+	for i := len(p) - 1; i >= 0; i-- {
+		b |= uint64(p[i]) << (8 * uint(i))
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = rounds(v0, v1, v2, v3, 2)
+	v0 ^= b
+	return v0, v1, v2, v3
+}
+
+func rounds(v0, v1, v2, v3 uint64, n int) (uint64, uint64, uint64, uint64) {
+	for i := 0; i < n; i++ {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	return v0, v1, v2, v3
+}
+
+// digest buffers the written bytes, SipHash needs the total length in its
+// last block.
+type digest struct {
+	key [KeySize]byte
+	buf []byte
+}
+
+// New returns a hash.Hash64 computing Sum64 under key. It can be used as a
+// jump.KeyHasher.
+func New(key [KeySize]byte) hash.Hash64 {
+	return &digest{key: key}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.buf = append(d.buf, p...)
+	return len(p), nil
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	var s [8]byte
+	binary.BigEndian.PutUint64(s[:], d.Sum64())
+	return append(b, s[:]...)
+}
+
+func (d *digest) Reset() {
+	d.buf = d.buf[:0]
+}
+
+func (d *digest) Size() int {
+	return 8
+}
+
+func (d *digest) BlockSize() int {
+	return 8
+}
+
+func (d *digest) Sum64() uint64 {
+	return Sum64(&d.key, d.buf)
+}
@@ -0,0 +1,73 @@
+package siphash
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// refKey is the key 00 01 .. 0f of the reference test vectors; their
+// messages are 00 01 .. n-1.
+var refKey = func() [KeySize]byte {
+	var k [KeySize]byte
+	for i := range k {
+		k[i] = byte(i)
+	}
+	return k
+}()
+
+func refMessage(n int) []byte {
+	m := make([]byte, n)
+	for i := range m {
+		m[i] = byte(i)
+	}
+	return m
+}
+
+var sum64TestVectors = []struct {
+	n        int
+	expected uint64
+}{
+	{0, 0x726fdb47dd0e0e31},
+	{8, 0x93f5f5799a932462},
+	{15, 0xa129ca6149be45e5},
+}
+
+func TestSum64(t *testing.T) {
+	for _, v := range sum64TestVectors {
+		if h := Sum64(&refKey, refMessage(v.n)); h != v.expected {
+			t.Errorf("expected SipHash of %d bytes to be %#x, got %#x", v.n, v.expected, h)
+		}
+		d := New(refKey)
+		d.Write(refMessage(v.n)[:v.n/2])
+		d.Write(refMessage(v.n)[v.n/2:])
+		if h := d.Sum64(); h != v.expected {
+			t.Errorf("expected streamed SipHash of %d bytes to be %#x, got %#x", v.n, v.expected, h)
+		}
+		if s := d.Sum(nil); binary.BigEndian.Uint64(s) != v.expected {
+			t.Errorf("Sum and Sum64 disagree: %x", s)
+		}
+		d.Reset()
+		if h := d.Sum64(); h != sum64TestVectors[0].expected {
+			t.Errorf("expected the empty SipHash after Reset, got %#x", h)
+		}
+	}
+}
+
+func TestSum128(t *testing.T) {
+	expected := [16]byte{0xa3, 0x81, 0x7f, 0x04, 0xba, 0x25, 0xa8, 0xe6, 0x6d, 0xf6, 0x72, 0x14, 0xc7, 0x55, 0x02, 0x93}
+	if h := Sum128(&refKey, nil); h != expected {
+		t.Errorf("expected SipHash-128 of the empty message to be %x, got %x", expected, h)
+	}
+}
+
+func TestStringHasher(t *testing.T) {
+	h := StringHasher(refKey)
+	if h("hello") != Sum64(&refKey, []byte("hello")) {
+		t.Error("StringHasher and Sum64 disagree")
+	}
+	other := refKey
+	other[0] ^= 1
+	if StringHasher(other)("hello") == h("hello") {
+		t.Error("different keys must give different hashes")
+	}
+}
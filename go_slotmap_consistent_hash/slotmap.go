@@ -0,0 +1,323 @@
+// Package slotmap maps keys to a fixed number of slots with jump consistent
+// hashing and slots to nodes with an editable table. Rebalancing is explicit:
+// Plan computes the fewest slot moves for new weights, and every move goes
+// through a migration during which lookups return both the old and the new
+// owner of the slot.
+package slotmap
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	jump "github.com/shanyux/consistent_hash/go_jump_consistent_hash"
+)
+
+var (
+	// ErrInvalidSlot is returned for a slot outside [0, NumSlots()).
+	ErrInvalidSlot = errors.New("slotmap: invalid slot")
+	// ErrMigrating is returned when changing the owner of a slot that is
+	// being migrated.
+	ErrMigrating = errors.New("slotmap: slot is migrating")
+	// ErrNotMigrating is returned when completing or aborting the migration
+	// of a stable slot.
+	ErrNotMigrating = errors.New("slotmap: slot is not migrating")
+	// ErrSameNode is returned when migrating a slot to its owner.
+	ErrSameNode = errors.New("slotmap: slot already belongs to node")
+	// ErrNoNodes is returned when assigning slots without any weight.
+	ErrNoNodes = errors.New("slotmap: no node with a positive weight")
+)
+
+// Hasher hashes a key to a 64 bit value.
+type Hasher func(s string) uint64
+
+// State is the migration state of a slot as seen by a node.
+type State int
+
+// Slot states.
+const (
+	// Stable means the node owns the slot, or has nothing to do with it,
+	// and no migration is going on.
+	Stable State = iota
+	// Migrating means the node owns the slot and is moving it away.
+	Migrating
+	// Importing means the node receives the slot from its owner.
+	Importing
+)
+
+func (s State) String() string {
+	switch s {
+	case Stable:
+		return "stable"
+	case Migrating:
+		return "migrating"
+	case Importing:
+		return "importing"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Route tells where the keys of a slot live.
+type Route struct {
+	Slot int
+	// Owner is the node owning the slot. During a migration it still has
+	// the keys that were not moved yet.
+	Owner string
+	// Importing is the node the slot is migrating to, or "".
+	Importing string
+}
+
+// Nodes returns the owner followed by the importing node, if any.
+func (r Route) Nodes() []string {
+	if r.Importing == "" {
+		return []string{r.Owner}
+	}
+	return []string{r.Owner, r.Importing}
+}
+
+// Move moves Slot from node From to node To.
+type Move struct {
+	Slot int
+	From string
+	To   string
+}
+
+// SlotMap is a slot table.
+// It is not safe for concurrent use.
+type SlotMap struct {
+	hash      Hasher
+	extract   func(key string) string
+	owners    []string
+	importing map[int]string
+}
+
+// Option configures a SlotMap.
+type Option func(m *SlotMap)
+
+// WithKeyExtractor makes the map hash extract(key) instead of key, so keys
+// sharing a hash tag land in the same slot.
+func WithKeyExtractor(extract func(key string) string) Option {
+	return func(m *SlotMap) {
+		m.extract = extract
+	}
+}
+
+// New returns a SlotMap with numSlots slots spread over the nodes of weights
+// in proportion to their weight.
+func New(numSlots int, weights map[string]uint, hash Hasher, opts ...Option) (*SlotMap, error) {
+	if numSlots < 1 {
+		return nil, ErrInvalidSlot
+	}
+	m := &SlotMap{
+		hash:      hash,
+		owners:    make([]string, numSlots),
+		importing: make(map[int]string),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	moves, err := m.Plan(weights)
+	if err != nil {
+		return nil, err
+	}
+	for _, mv := range moves {
+		m.owners[mv.Slot] = mv.To
+	}
+	return m, nil
+}
+
+// NumSlots returns the number of slots.
+func (m *SlotMap) NumSlots() int {
+	return len(m.owners)
+}
+
+// KeySlot returns the slot of key.
+func (m *SlotMap) KeySlot(key string) int {
+	if m.extract != nil {
+		key = m.extract(key)
+	}
+	return int(jump.JumpHash(m.hash(key), int32(len(m.owners))))
+}
+
+// Lookup returns the route of the slot of key.
+func (m *SlotMap) Lookup(key string) Route {
+	r, _ := m.Route(m.KeySlot(key))
+	return r
+}
+
+// Route returns the route of slot.
+func (m *SlotMap) Route(slot int) (Route, error) {
+	if slot < 0 || slot >= len(m.owners) {
+		return Route{}, ErrInvalidSlot
+	}
+	return Route{Slot: slot, Owner: m.owners[slot], Importing: m.importing[slot]}, nil
+}
+
+// Slots returns the slots owned by node in increasing order.
+func (m *SlotMap) Slots(node string) []int {
+	var res []int
+	for s, owner := range m.owners {
+		if owner == node {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// SetOwner makes node the owner of slot without a migration.
+func (m *SlotMap) SetOwner(slot int, node string) error {
+	if slot < 0 || slot >= len(m.owners) {
+		return ErrInvalidSlot
+	}
+	if _, ok := m.importing[slot]; ok {
+		return ErrMigrating
+	}
+	m.owners[slot] = node
+	return nil
+}
+
+// State returns the state of slot as seen by node.
+func (m *SlotMap) State(slot int, node string) State {
+	if slot < 0 || slot >= len(m.owners) {
+		return Stable
+	}
+	to, ok := m.importing[slot]
+	switch {
+	case !ok:
+		return Stable
+	case node == m.owners[slot]:
+		return Migrating
+	case node == to:
+		return Importing
+	}
+	return Stable
+}
+
+// BeginMigration starts moving slot from its owner to node.
+func (m *SlotMap) BeginMigration(slot int, node string) error {
+	if slot < 0 || slot >= len(m.owners) {
+		return ErrInvalidSlot
+	}
+	if _, ok := m.importing[slot]; ok {
+		return ErrMigrating
+	}
+	if m.owners[slot] == node {
+		return ErrSameNode
+	}
+	m.importing[slot] = node
+	return nil
+}
+
+// CompleteMigration makes the importing node the owner of slot.
+func (m *SlotMap) CompleteMigration(slot int) error {
+	to, ok := m.importing[slot]
+	if !ok {
+		return ErrNotMigrating
+	}
+	m.owners[slot] = to
+	delete(m.importing, slot)
+	return nil
+}
+
+// AbortMigration leaves slot with its owner.
+func (m *SlotMap) AbortMigration(slot int) error {
+	if _, ok := m.importing[slot]; !ok {
+		return ErrNotMigrating
+	}
+	delete(m.importing, slot)
+	return nil
+}
+
+// Migrations returns the slots being migrated in increasing order.
+func (m *SlotMap) Migrations() []Move {
+	res := make([]Move, 0, len(m.importing))
+	for s, to := range m.importing {
+		res = append(res, Move{Slot: s, From: m.owners[s], To: to})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Slot < res[j].Slot })
+	return res
+}
+
+// Plan returns the fewest slot moves giving every node of weights a share
+// of the slots proportional to its weight. Nodes missing from weights or
+// with weight 0 give all their slots away. Slots being migrated count for
+// the importing node and are never moved.
+func (m *SlotMap) Plan(weights map[string]uint) ([]Move, error) {
+	target, err := targets(len(m.owners), weights)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count what every node will own once the migrations are done, and
+	// collect the slots they have too many of.
+	count := make(map[string]int)
+	for s, owner := range m.owners {
+		if to, ok := m.importing[s]; ok {
+			count[to]++
+		} else {
+			count[owner]++
+		}
+	}
+	var surplus []Move
+	for s := len(m.owners) - 1; s >= 0; s-- {
+		owner := m.owners[s]
+		if _, ok := m.importing[s]; ok {
+			continue
+		}
+		if owner == "" || count[owner] > target[owner] {
+			count[owner]--
+			surplus = append(surplus, Move{Slot: s, From: owner})
+		}
+	}
+
+	// Hand the surplus out to the nodes below their target.
+	names := make([]string, 0, len(target))
+	for name := range target {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	moves := make([]Move, 0, len(surplus))
+	for _, name := range names {
+		for count[name] < target[name] && len(surplus) > 0 {
+			mv := surplus[len(surplus)-1]
+			surplus = surplus[:len(surplus)-1]
+			mv.To = name
+			moves = append(moves, mv)
+			count[name]++
+		}
+	}
+	sort.Slice(moves, func(i, j int) bool { return moves[i].Slot < moves[j].Slot })
+	return moves, nil
+}
+
+// targets splits numSlots by weight with the largest remainder method, ties
+// going to the smaller name.
+func targets(numSlots int, weights map[string]uint) (map[string]int, error) {
+	var total uint64
+	names := make([]string, 0, len(weights))
+	for name, w := range weights {
+		if w > 0 {
+			total += uint64(w)
+			names = append(names, name)
+		}
+	}
+	if total == 0 {
+		return nil, ErrNoNodes
+	}
+	sort.Strings(names)
+
+	target := make(map[string]int, len(names))
+	rem := make(map[string]uint64, len(names))
+	left := numSlots
+	for _, name := range names {
+		q := uint64(numSlots) * uint64(weights[name])
+		target[name] = int(q / total)
+		rem[name] = q % total
+		left -= target[name]
+	}
+	sort.SliceStable(names, func(i, j int) bool { return rem[names[i]] > rem[names[j]] })
+	for i := 0; i < left; i++ {
+		target[names[i]]++
+	}
+	return target, nil
+}
@@ -0,0 +1,178 @@
+package slotmap
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getWeights(nodenum int) map[string]uint {
+	weights := make(map[string]uint, nodenum)
+	for i := 0; i < nodenum; i++ {
+		weights[fmt.Sprintf("127.0.0.1:800%d", i)] = 1
+	}
+	return weights
+}
+
+func owned(m *SlotMap) map[string]int {
+	res := make(map[string]int)
+	for _, owner := range m.owners {
+		res[owner]++
+	}
+	return res
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(0, getWeights(3), hashString); err != ErrInvalidSlot {
+		t.Errorf("expected ErrInvalidSlot, got %v", err)
+	}
+	if _, err := New(16, map[string]uint{"a": 0}, hashString); err != ErrNoNodes {
+		t.Errorf("expected ErrNoNodes, got %v", err)
+	}
+	m, err := New(1024, getWeights(3), hashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node, n := range owned(m) {
+		if n != 341 && n != 342 {
+			t.Errorf("%s owns %d slots, expected 341 or 342", node, n)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		r := m.Lookup(key)
+		if r.Slot != m.KeySlot(key) || r.Owner != m.owners[r.Slot] || r.Importing != "" {
+			t.Errorf("unexpected route %v for %s", r, key)
+		}
+	}
+}
+
+func TestPlanMinimalMoves(t *testing.T) {
+	weights := getWeights(4)
+	m, _ := New(1000, weights, hashString)
+
+	// A new node only takes slots.
+	weights["127.0.0.1:9000"] = 1
+	moves, _ := m.Plan(weights)
+	if len(moves) != 200 {
+		t.Errorf("expected 200 moves, got %d", len(moves))
+	}
+	for _, mv := range moves {
+		if mv.To != "127.0.0.1:9000" || mv.From == mv.To {
+			t.Errorf("unexpected move %v", mv)
+		}
+		m.SetOwner(mv.Slot, mv.To)
+	}
+	for node, n := range owned(m) {
+		if n != 200 {
+			t.Errorf("%s owns %d slots, expected 200", node, n)
+		}
+	}
+
+	// Doubling a weight moves exactly the difference, only to that node.
+	weights["127.0.0.1:8000"] = 2
+	moves, _ = m.Plan(weights)
+	if len(moves) != 333-200 {
+		t.Errorf("expected %d moves, got %d", 333-200, len(moves))
+	}
+	for _, mv := range moves {
+		if mv.To != "127.0.0.1:8000" {
+			t.Errorf("unexpected move %v", mv)
+		}
+	}
+
+	// A removed node gives all its slots away and nothing else moves.
+	delete(weights, "127.0.0.1:8000")
+	moves, _ = m.Plan(weights)
+	if len(moves) != 200 {
+		t.Errorf("expected 200 moves, got %d", len(moves))
+	}
+	for _, mv := range moves {
+		if mv.From != "127.0.0.1:8000" {
+			t.Errorf("unexpected move %v", mv)
+		}
+		m.SetOwner(mv.Slot, mv.To)
+	}
+	if moves, _ = m.Plan(weights); len(moves) != 0 {
+		t.Errorf("expected a balanced map, got %v", moves)
+	}
+}
+
+func TestMigration(t *testing.T) {
+	m, _ := New(64, map[string]uint{"a": 1, "b": 1}, hashString)
+	key := "user:42"
+	s := m.KeySlot(key)
+	from := m.Lookup(key).Owner
+	to := "c"
+
+	if err := m.BeginMigration(s, from); err != ErrSameNode {
+		t.Errorf("expected ErrSameNode, got %v", err)
+	}
+	if err := m.CompleteMigration(s); err != ErrNotMigrating {
+		t.Errorf("expected ErrNotMigrating, got %v", err)
+	}
+	if err := m.BeginMigration(s, to); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BeginMigration(s, "d"); err != ErrMigrating {
+		t.Errorf("expected ErrMigrating, got %v", err)
+	}
+	if err := m.SetOwner(s, "d"); err != ErrMigrating {
+		t.Errorf("expected ErrMigrating, got %v", err)
+	}
+	if st := m.State(s, from); st != Migrating {
+		t.Errorf("expected %s to be migrating, got %v", from, st)
+	}
+	if st := m.State(s, to); st != Importing {
+		t.Errorf("expected %s to be importing, got %v", to, st)
+	}
+	r := m.Lookup(key)
+	if ns := r.Nodes(); len(ns) != 2 || ns[0] != from || ns[1] != to {
+		t.Errorf("expected both owners during the migration, got %v", ns)
+	}
+	if ms := m.Migrations(); len(ms) != 1 || ms[0] != (Move{s, from, to}) {
+		t.Errorf("unexpected migrations %v", ms)
+	}
+
+	// The migrating slot already counts for c and is left alone.
+	moves, _ := m.Plan(map[string]uint{"a": 1, "b": 1, "c": 1})
+	for _, mv := range moves {
+		if mv.Slot == s {
+			t.Errorf("migrating slot planned again: %v", mv)
+		}
+	}
+	if len(moves) != 20 {
+		t.Errorf("expected 20 more moves, got %d", len(moves))
+	}
+
+	m.AbortMigration(s)
+	if r := m.Lookup(key); r.Owner != from || r.Importing != "" || m.State(s, to) != Stable {
+		t.Errorf("expected the slot back on %s, got %v", from, r)
+	}
+	m.BeginMigration(s, to)
+	m.CompleteMigration(s)
+	if r := m.Lookup(key); r.Owner != to || r.Importing != "" || m.State(s, to) != Stable {
+		t.Errorf("expected the slot on %s, got %v", to, r)
+	}
+	if _, err := m.Route(64); err != ErrInvalidSlot {
+		t.Errorf("expected ErrInvalidSlot, got %v", err)
+	}
+}
+
+func TestKeyExtractor(t *testing.T) {
+	prefix := func(key string) string { return key[:4] }
+	m, _ := New(128, getWeights(4), hashString, WithKeyExtractor(prefix))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("%04d", i)
+		if m.KeySlot(key+"profile") != m.KeySlot(key+"cart") {
+			t.Errorf("keys with prefix %s are in different slots", key)
+		}
+	}
+}
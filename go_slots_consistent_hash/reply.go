@@ -0,0 +1,92 @@
+package slots
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ParseClusterSlots parses a CLUSTER SLOTS reply as returned by most Redis
+// clients: an array of [start, end, master, replica...] entries, each node
+// being [ip, port] optionally followed by its ID. Integers may be int,
+// int64 or numeric strings, strings may also be []byte.
+func ParseClusterSlots(reply []interface{}) ([]SlotRange, error) {
+	ranges := make([]SlotRange, 0, len(reply))
+	for i, e := range reply {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) < 3 {
+			return nil, fmt.Errorf("slots: CLUSTER SLOTS entry %d: expected [start, end, master, ...]", i)
+		}
+		start, err := toInt(entry[0])
+		if err != nil {
+			return nil, fmt.Errorf("slots: CLUSTER SLOTS entry %d: start: %v", i, err)
+		}
+		end, err := toInt(entry[1])
+		if err != nil {
+			return nil, fmt.Errorf("slots: CLUSTER SLOTS entry %d: end: %v", i, err)
+		}
+		if !validSlot(start) || !validSlot(end) || start > end {
+			return nil, fmt.Errorf("slots: CLUSTER SLOTS entry %d: %v: [%d, %d]", i, ErrInvalidSlot, start, end)
+		}
+		r := SlotRange{Start: start, End: end}
+		for j, n := range entry[2:] {
+			node, err := parseNode(n)
+			if err != nil {
+				return nil, fmt.Errorf("slots: CLUSTER SLOTS entry %d: node %d: %v", i, j, err)
+			}
+			if j == 0 {
+				r.Master = node
+			} else {
+				r.Replicas = append(r.Replicas, node)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseNode(v interface{}) (Node, error) {
+	n, ok := v.([]interface{})
+	if !ok || len(n) < 2 {
+		return Node{}, fmt.Errorf("expected [ip, port, ...]")
+	}
+	ip, err := toString(n[0])
+	if err != nil {
+		return Node{}, err
+	}
+	port, err := toInt(n[1])
+	if err != nil {
+		return Node{}, err
+	}
+	node := Node{Addr: net.JoinHostPort(ip, strconv.Itoa(port))}
+	if len(n) > 2 {
+		if node.ID, err = toString(n[2]); err != nil {
+			return Node{}, err
+		}
+	}
+	return node, nil
+}
+
+func toInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	case []byte:
+		return strconv.Atoi(string(v))
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", v)
+}
+
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("expected a string, got %T", v)
+}
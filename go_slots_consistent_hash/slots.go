@@ -0,0 +1,62 @@
+// Package slots implements Redis Cluster hash slot routing[1]. A key maps to
+// one of 16384 slots by the CRC16 of its hash tag, and every slot is served
+// by one master. While a slot is resharded it is migrating on its owner and
+// importing on its new node, and clients are sent MOVED and ASK redirects.
+//
+// [1] https://redis.io/docs/reference/cluster-spec/
+package slots
+
+// NumSlots is the number of hash slots of a Redis Cluster.
+const NumSlots = 16384
+
+// crc16tab is the CRC16-CCITT (XMODEM) table: polynomial 0x1021, initial
+// value 0, no reflection.
+var crc16tab = func() [256]uint16 {
+	var tab [256]uint16
+	for i := range tab {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		tab[i] = crc
+	}
+	return tab
+}()
+
+// CRC16 returns the CRC16 Redis Cluster uses for key hashing.
+func CRC16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// HashTag returns the part of key that is hashed: the content of the first
+// "{...}" if it is not empty, the whole key otherwise.
+func HashTag(key string) string {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
+
+// KeySlot returns the hash slot of key.
+func KeySlot(key string) int {
+	return int(CRC16(HashTag(key)) & (NumSlots - 1))
+}
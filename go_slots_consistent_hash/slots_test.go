@@ -0,0 +1,240 @@
+package slots
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	// Test vector of the Redis Cluster specification.
+	if crc := CRC16("123456789"); crc != 0x31c3 {
+		t.Errorf("expected CRC16(\"123456789\") = 0x31c3, got %#x", crc)
+	}
+	if crc := CRC16(""); crc != 0 {
+		t.Errorf("expected CRC16(\"\") = 0, got %#x", crc)
+	}
+}
+
+var keySlotTestVectors = []struct {
+	key  string
+	slot int
+}{
+	{"foo", 12182},
+	{"bar", 5061},
+	{"hello", 866},
+	{"somekey", 11058},
+	{"123456789", 0x31c3},
+}
+
+func TestKeySlot(t *testing.T) {
+	for _, v := range keySlotTestVectors {
+		if s := KeySlot(v.key); s != v.slot {
+			t.Errorf("expected slot of %s to be %d, got %d", v.key, v.slot, s)
+		}
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	for key, tag := range map[string]string{
+		"{user1000}.following": "user1000",
+		"{user1000}.followers": "user1000",
+		"foo{}{bar}":           "foo{}{bar}",
+		"foo{{bar}}zap":        "{bar",
+		"foo{bar}{zap}":        "bar",
+		"foo{bar":              "foo{bar",
+		"foo}bar{":             "foo}bar{",
+		"{}":                   "{}",
+		"":                     "",
+	} {
+		if got := HashTag(key); got != tag {
+			t.Errorf("expected hash tag of %q to be %q, got %q", key, tag, got)
+		}
+	}
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag must share a slot")
+	}
+}
+
+var (
+	nodeA = Node{Addr: "127.0.0.1:7000", ID: "a"}
+	nodeB = Node{Addr: "127.0.0.1:7001", ID: "b"}
+	nodeC = Node{Addr: "127.0.0.1:7002", ID: "c"}
+)
+
+func getTable(t *testing.T) *Table {
+	table, err := NewTable([]SlotRange{
+		{Start: 0, End: 5460, Master: nodeA, Replicas: []Node{nodeB}},
+		{Start: 5461, End: 10922, Master: nodeB},
+		{Start: 10923, End: 16383, Master: nodeC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestTable(t *testing.T) {
+	table := getTable(t)
+	for _, v := range keySlotTestVectors {
+		n, err := table.Lookup(v.key)
+		expected := nodeA
+		if v.slot > 10922 {
+			expected = nodeC
+		} else if v.slot > 5460 {
+			expected = nodeB
+		}
+		if err != nil || n != expected {
+			t.Errorf("expected %s on %v, got %v, %v", v.key, expected, n, err)
+		}
+	}
+	if _, err := NewTable([]SlotRange{{Start: 10, End: 5, Master: nodeA}}); err == nil {
+		t.Error("expected invalid range error")
+	}
+	if _, err := NewTable([]SlotRange{{Start: 0, End: NumSlots, Master: nodeA}}); err == nil {
+		t.Error("expected invalid range error")
+	}
+	empty, _ := NewTable(nil)
+	if _, err := empty.Lookup("foo"); err != ErrNotOwned {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+
+	table.SetNode(100, nodeC)
+	ranges := table.Ranges()
+	if len(ranges) != 5 {
+		t.Fatalf("expected 5 ranges, got %v", ranges)
+	}
+	if r := ranges[1]; r.Start != 100 || r.End != 100 || r.Master != nodeC {
+		t.Errorf("expected slot 100 on its own range, got %v", r)
+	}
+	if r := ranges[2]; r.Start != 101 || r.End != 5460 || len(r.Replicas) != 1 {
+		t.Errorf("expected [101, 5460] with a replica, got %v", r)
+	}
+}
+
+func TestMigration(t *testing.T) {
+	key := "foo"
+	s := KeySlot(key)
+	// a and b see the cluster differently while the slot moves from c to a.
+	onC := getTable(t)
+	onA := getTable(t)
+	if err := onC.SetMigrating(s, nodeA); err != nil {
+		t.Fatal(err)
+	}
+	onA.SetImporting(s, nodeC)
+	if state, peer := onC.State(s); state != Migrating || peer != nodeA {
+		t.Errorf("expected migrating to a, got %v %v", state, peer)
+	}
+
+	// c still serves the keys it has and asks for the others.
+	if r, _ := onC.Check(nodeC, key, true, false); r != nil {
+		t.Errorf("expected c to serve an existing key, got %v", r)
+	}
+	r, _ := onC.Check(nodeC, key, false, false)
+	if r == nil || r.Kind != Ask || r.Addr != nodeA.Addr || r.Slot != s {
+		t.Fatalf("expected ASK to a, got %v", r)
+	}
+	// a only serves the slot after ASKING.
+	if r, _ := onA.Check(nodeA, key, false, true); r != nil {
+		t.Errorf("expected a to serve after ASKING, got %v", r)
+	}
+	if r, _ := onA.Check(nodeA, key, false, false); r == nil || r.Kind != Moved || r.Addr != nodeC.Addr {
+		t.Errorf("expected MOVED to c, got %v", r)
+	}
+
+	// The client retries once on a without updating its table.
+	client := getTable(t)
+	n, _ := client.HandleRedirect(*r)
+	if n.Addr != nodeA.Addr {
+		t.Errorf("expected to retry on a, got %v", n)
+	}
+	if m, _ := client.Lookup(key); m != nodeC {
+		t.Errorf("ASK must not change the master, got %v", m)
+	}
+
+	// Once the slot moved, c answers MOVED and the client learns it.
+	onC.SetNode(s, nodeA)
+	r, _ = onC.Check(nodeC, key, false, false)
+	if r == nil || r.Kind != Moved || r.Addr != nodeA.Addr {
+		t.Fatalf("expected MOVED to a, got %v", r)
+	}
+	client.HandleRedirect(*r)
+	if m, _ := client.Lookup(key); m.Addr != nodeA.Addr {
+		t.Errorf("expected a to be the master after MOVED, got %v", m)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	r, err := ParseRedirect("MOVED 3999 127.0.0.1:6381")
+	if err != nil || r != (Redirect{Moved, 3999, "127.0.0.1:6381"}) {
+		t.Errorf("unexpected %v, %v", r, err)
+	}
+	r, err = ParseRedirect("ASK 3999 127.0.0.1:6381")
+	if err != nil || r != (Redirect{Ask, 3999, "127.0.0.1:6381"}) {
+		t.Errorf("unexpected %v, %v", r, err)
+	}
+	for _, s := range []string{"", "MOVED 3999", "MOVED x 127.0.0.1:6381", "MOVED 16384 127.0.0.1:6381", "ERR 1 a"} {
+		if _, err := ParseRedirect(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(5460),
+			[]interface{}{[]byte("127.0.0.1"), int64(30001), []byte("09dbe9720cda62f7865eabc5fd8857c5d2678366")},
+			[]interface{}{[]byte("127.0.0.1"), int64(30004), []byte("821d8ca00d7ccf931ed3ffc7e3db0599d2271abf")},
+		},
+		[]interface{}{"5461", "10922", []interface{}{"127.0.0.1", 30002}},
+	}
+	ranges, err := ParseClusterSlots(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("expected 2 ranges, got %v", ranges)
+	}
+	r := ranges[0]
+	if r.Start != 0 || r.End != 5460 || r.Master.Addr != "127.0.0.1:30001" ||
+		r.Master.ID != "09dbe9720cda62f7865eabc5fd8857c5d2678366" || len(r.Replicas) != 1 ||
+		r.Replicas[0].Addr != "127.0.0.1:30004" {
+		t.Errorf("unexpected range %v", r)
+	}
+	if r := ranges[1]; r.Start != 5461 || r.End != 10922 || r.Master != (Node{Addr: "127.0.0.1:30002"}) {
+		t.Errorf("unexpected range %v", r)
+	}
+	table, _ := NewTable(ranges)
+	if n, _ := table.Lookup("foo"); n.Addr != "" {
+		t.Errorf("expected slot 12182 to be unowned, got %v", n)
+	}
+
+	ranges, err = ParseClusterSlots([]interface{}{
+		[]interface{}{0, 16383, []interface{}{"::1", 7000}},
+	})
+	if err != nil || len(ranges) != 1 || ranges[0].Master.Addr != "[::1]:7000" {
+		t.Errorf("unexpected %v, %v", ranges, err)
+	}
+
+	for i, bad := range [][]interface{}{
+		{"x"},
+		{[]interface{}{0, 1}},
+		{[]interface{}{"a", 1, []interface{}{"127.0.0.1", 1}}},
+		{[]interface{}{5, 1, []interface{}{"127.0.0.1", 1}}},
+		{[]interface{}{0, 1, []interface{}{"127.0.0.1"}}},
+		{[]interface{}{0, 1, []interface{}{1, 1}}},
+	} {
+		if _, err := ParseClusterSlots(bad); err == nil {
+			t.Errorf("expected an error for reply %d", i)
+		}
+	}
+}
+
+func BenchmarkKeySlot(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "user:{" + strconv.Itoa(i) + "}:profile"
+	}
+	for i := 0; i < b.N; i++ {
+		KeySlot(keys[i&1023])
+	}
+}
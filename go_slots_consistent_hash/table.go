@@ -0,0 +1,281 @@
+package slots
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidSlot is returned for a slot outside [0, NumSlots).
+	ErrInvalidSlot = errors.New("slots: invalid slot")
+	// ErrNotOwned is returned for a slot without a master.
+	ErrNotOwned = errors.New("slots: slot has no master")
+)
+
+// Node is a cluster node. ID may be empty when only the address is known.
+type Node struct {
+	Addr string
+	ID   string
+}
+
+// SlotRange is the slots [Start, End], both inclusive as in CLUSTER SLOTS,
+// and the nodes serving them.
+type SlotRange struct {
+	Start    int
+	End      int
+	Master   Node
+	Replicas []Node
+}
+
+// State is the migration state of a slot.
+type State int
+
+// Slot migration states.
+const (
+	// Stable slots are only served by their master.
+	Stable State = iota
+	// Migrating slots are moving from their master to another node. The
+	// master answers with ASK for keys it no longer has.
+	Migrating
+	// Importing slots are moving to a node from their master. The node
+	// accepts commands for them after ASKING.
+	Importing
+)
+
+func (s State) String() string {
+	switch s {
+	case Stable:
+		return "stable"
+	case Migrating:
+		return "migrating"
+	case Importing:
+		return "importing"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// owner holds the nodes serving a slot, shared by the slots of a range.
+type owner struct {
+	master   Node
+	replicas []Node
+}
+
+type slotInfo struct {
+	owner *owner
+	state State
+	peer  Node
+}
+
+// Table maps slots to nodes as seen by a client.
+// It is not safe for concurrent use.
+type Table struct {
+	slots [NumSlots]slotInfo
+}
+
+// NewTable returns a Table serving ranges. Later ranges win on overlaps.
+func NewTable(ranges []SlotRange) (*Table, error) {
+	t := &Table{}
+	for _, r := range ranges {
+		if err := t.SetRange(r); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func validSlot(s int) bool {
+	return s >= 0 && s < NumSlots
+}
+
+// SetRange assigns the slots of r to its nodes. The slots become stable.
+func (t *Table) SetRange(r SlotRange) error {
+	if !validSlot(r.Start) || !validSlot(r.End) || r.Start > r.End {
+		return fmt.Errorf("%v: [%d, %d]", ErrInvalidSlot, r.Start, r.End)
+	}
+	o := &owner{master: r.Master, replicas: append([]Node(nil), r.Replicas...)}
+	for s := r.Start; s <= r.End; s++ {
+		t.slots[s] = slotInfo{owner: o}
+	}
+	return nil
+}
+
+// Master returns the master of slot.
+func (t *Table) Master(slot int) (Node, error) {
+	if !validSlot(slot) {
+		return Node{}, ErrInvalidSlot
+	}
+	if t.slots[slot].owner == nil {
+		return Node{}, ErrNotOwned
+	}
+	return t.slots[slot].owner.master, nil
+}
+
+// Lookup returns the master of the slot of key.
+func (t *Table) Lookup(key string) (Node, error) {
+	return t.Master(KeySlot(key))
+}
+
+// State returns the migration state of slot and the node it is migrating
+// to or importing from.
+func (t *Table) State(slot int) (State, Node) {
+	if !validSlot(slot) {
+		return Stable, Node{}
+	}
+	return t.slots[slot].state, t.slots[slot].peer
+}
+
+// SetMigrating marks slot as migrating from its master to target, like
+// CLUSTER SETSLOT MIGRATING on the master.
+func (t *Table) SetMigrating(slot int, target Node) error {
+	return t.setState(slot, Migrating, target)
+}
+
+// SetImporting marks slot as imported from source, its master, like
+// CLUSTER SETSLOT IMPORTING on the node receiving the slot.
+func (t *Table) SetImporting(slot int, source Node) error {
+	return t.setState(slot, Importing, source)
+}
+
+// SetStable ends the migration of slot without changing its master.
+func (t *Table) SetStable(slot int) error {
+	return t.setState(slot, Stable, Node{})
+}
+
+// SetNode ends the migration of slot and makes node its master, like
+// CLUSTER SETSLOT NODE.
+func (t *Table) SetNode(slot int, node Node) error {
+	if !validSlot(slot) {
+		return ErrInvalidSlot
+	}
+	t.slots[slot] = slotInfo{owner: &owner{master: node}}
+	return nil
+}
+
+func (t *Table) setState(s int, state State, peer Node) error {
+	if !validSlot(s) {
+		return ErrInvalidSlot
+	}
+	if t.slots[s].owner == nil {
+		return ErrNotOwned
+	}
+	t.slots[s].state = state
+	t.slots[s].peer = peer
+	return nil
+}
+
+// Ranges returns the owned slots as ranges of consecutive slots with the
+// same nodes, sorted by slot.
+func (t *Table) Ranges() []SlotRange {
+	var res []SlotRange
+	for s := 0; s < NumSlots; s++ {
+		o := t.slots[s].owner
+		if o == nil {
+			continue
+		}
+		if n := len(res); n > 0 && res[n-1].End == s-1 && sameNodes(&res[n-1], o) {
+			res[n-1].End = s
+			continue
+		}
+		res = append(res, SlotRange{
+			Start:    s,
+			End:      s,
+			Master:   o.master,
+			Replicas: append([]Node(nil), o.replicas...),
+		})
+	}
+	return res
+}
+
+func sameNodes(r *SlotRange, o *owner) bool {
+	if r.Master != o.master || len(r.Replicas) != len(o.replicas) {
+		return false
+	}
+	for i := range r.Replicas {
+		if r.Replicas[i] != o.replicas[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns the redirect a Redis node at self, whose view of the
+// cluster is t, answers for a command on key, or nil if self serves it.
+// exists tells whether self still stores key and asking whether the client
+// sent ASKING first.
+func (t *Table) Check(self Node, key string, exists, asking bool) (*Redirect, error) {
+	s := KeySlot(key)
+	info := t.slots[s]
+	if info.owner == nil {
+		return nil, ErrNotOwned
+	}
+	if info.owner.master.Addr == self.Addr {
+		if info.state == Migrating && !exists {
+			return &Redirect{Kind: Ask, Slot: s, Addr: info.peer.Addr}, nil
+		}
+		return nil, nil
+	}
+	if info.state == Importing && asking {
+		return nil, nil
+	}
+	return &Redirect{Kind: Moved, Slot: s, Addr: info.owner.master.Addr}, nil
+}
+
+// RedirectKind tells MOVED from ASK redirects.
+type RedirectKind int
+
+// Redirect kinds.
+const (
+	// Moved means the slot has a new master for good.
+	Moved RedirectKind = iota
+	// Ask means the key must be asked once to another node, prefixed by
+	// ASKING, because its slot is being migrated.
+	Ask
+)
+
+// Redirect is a parsed MOVED or ASK error.
+type Redirect struct {
+	Kind RedirectKind
+	Slot int
+	Addr string
+}
+
+// ParseRedirect parses an error reply like "MOVED 3999 127.0.0.1:6381" or
+// "ASK 3999 127.0.0.1:6381".
+func ParseRedirect(reply string) (Redirect, error) {
+	f := strings.Fields(reply)
+	if len(f) != 3 {
+		return Redirect{}, fmt.Errorf("slots: invalid redirect %q", reply)
+	}
+	var r Redirect
+	switch f[0] {
+	case "MOVED":
+		r.Kind = Moved
+	case "ASK":
+		r.Kind = Ask
+	default:
+		return Redirect{}, fmt.Errorf("slots: invalid redirect %q", reply)
+	}
+	s, err := strconv.Atoi(f[1])
+	if err != nil || !validSlot(s) {
+		return Redirect{}, fmt.Errorf("slots: invalid redirect slot %q", reply)
+	}
+	r.Slot = s
+	r.Addr = f[2]
+	return r, nil
+}
+
+// HandleRedirect returns the node to retry the command on. A MOVED redirect
+// also makes that node the master of the slot; an ASK redirect leaves the
+// table untouched as it is only valid for one command.
+func (t *Table) HandleRedirect(r Redirect) (Node, error) {
+	if !validSlot(r.Slot) {
+		return Node{}, ErrInvalidSlot
+	}
+	node := Node{Addr: r.Addr}
+	if r.Kind == Moved {
+		t.SetNode(r.Slot, node)
+	}
+	return node, nil
+}
@@ -0,0 +1,32 @@
+// Package splitmix64 implements the finalizer of the splitmix64 generator[1],
+// which the packages of this module use to mix hashes: a bijection of 64 bit
+// values where every input bit flips each output bit with probability about
+// 1/2.
+//
+// [1] https://prng.di.unimi.it/splitmix64.c
+package splitmix64
+
+// Gamma is the increment of the splitmix64 generator, the 64 bit golden
+// ratio. Mix(h + i*Gamma) for i = 0, 1, ... are independent hashes derived
+// from h.
+const Gamma = 0x9e3779b97f4a7c15
+
+// Mix is the splitmix64 finalizer.
+func Mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Seed salts the hash h with seed, returning the output of the generator
+// in state h^seed. Seed 0 returns h unchanged, the unsalted hash. Every
+// package of this module salts its hashes this way, so a seed places keys
+// the same way whichever package hashes them.
+func Seed(h, seed uint64) uint64 {
+	if seed == 0 {
+		return h
+	}
+	return Mix((h ^ seed) + Gamma)
+}
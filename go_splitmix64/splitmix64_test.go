@@ -0,0 +1,47 @@
+package splitmix64
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func TestMix(t *testing.T) {
+	// The first outputs of the reference generator seeded with 0, which
+	// mixes the seed plus i*Gamma.
+	want := []uint64{0xe220a8397b1dcdaf, 0x6e789e6aa1b965f4, 0x06c45d188009454f}
+	for i, w := range want {
+		if got := Mix(uint64(i+1) * Gamma); got != w {
+			t.Errorf("output %d: expected %#x, got %#x", i, w, got)
+		}
+	}
+	if Mix(0) != 0 {
+		t.Error("0 must be a fixed point of Mix")
+	}
+}
+
+func TestAvalanche(t *testing.T) {
+	// Flipping an input bit flips each output bit about half the time.
+	const n = 2000
+	for bit := uint(0); bit < 64; bit++ {
+		flips := 0
+		for i := uint64(1); i <= n; i++ {
+			flips += bits.OnesCount64(Mix(i*Gamma) ^ Mix(i*Gamma^1<<bit))
+		}
+		if r := float64(flips) / (64 * n); r < 0.48 || r > 0.52 {
+			t.Errorf("bit %d flips %f of the output bits", bit, r)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	if Seed(42, 0) != 42 {
+		t.Error("seed 0 must leave the hash unchanged")
+	}
+	// The salted hash is the generator output in state h^seed.
+	if got := Seed(7, 7); got != 0xe220a8397b1dcdaf {
+		t.Errorf("expected Seed(7, 7) = %#x, got %#x", uint64(0xe220a8397b1dcdaf), got)
+	}
+	if Seed(42, 1) == Seed(42, 2) {
+		t.Error("different seeds must salt differently")
+	}
+}
@@ -0,0 +1,81 @@
+// Package subsetting implements deterministic subsetting[1]: each client
+// connects to a small subset of the backends, and the subsets of
+// consecutive client ids spread the connections evenly over the backends.
+//
+// Clients are grouped in rounds of len(backends)/subsetSize clients. Each
+// round shuffles the backends and cuts them into disjoint subsets, one per
+// client of the round, so every backend gets the same number of
+// connections from a complete round.
+//
+// [1] https://sre.google/sre-book/load-balancing-datacenter/
+package subsetting
+
+import (
+	"sort"
+
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// Hasher hashes a backend name to a 64 bit value.
+type Hasher func(s string) uint64
+
+// Subset returns the subsetSize backends client clientID connects to, or
+// all of them if there are not more than subsetSize. Client IDs start at
+// 0, a negative one gets no backend.
+//
+// The shuffle of a round orders the backends by a hash of their name mixed
+// with the round rather than shuffling positions, so adding or removing a
+// backend changes each subset by at most one backend, as long as the number
+// of subsets len(backends)/subsetSize stays the same. When it changes the
+// clients are regrouped into new rounds.
+func Subset(backends []string, clientID, subsetSize int, hash Hasher) []string {
+	if subsetSize <= 0 || clientID < 0 {
+		return nil
+	}
+	if len(backends) <= subsetSize {
+		res := make([]string, len(backends))
+		copy(res, backends)
+		return res
+	}
+	subsetCount := len(backends) / subsetSize
+	round := clientID / subsetCount
+	subsetID := clientID % subsetCount
+
+	shuffled := shuffle(backends, uint64(round), hash)
+	start := subsetID * subsetSize
+	res := make([]string, subsetSize)
+	copy(res, shuffled[start:start+subsetSize])
+	return res
+}
+
+// shuffle returns backends ordered by their hash mixed with round.
+func shuffle(backends []string, round uint64, hash Hasher) []string {
+	keys := make(map[string]uint64, len(backends))
+	res := make([]string, len(backends))
+	for i, b := range backends {
+		keys[b] = splitmix64.Mix(hash(b) + round*splitmix64.Gamma)
+		res[i] = b
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if keys[res[i]] != keys[res[j]] {
+			return keys[res[i]] < keys[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// Connections returns the number of clients, with ids 0 to clients-1,
+// connected to each backend.
+func Connections(backends []string, clients, subsetSize int, hash Hasher) map[string]int {
+	res := make(map[string]int, len(backends))
+	for _, b := range backends {
+		res[b] = 0
+	}
+	for id := 0; id < clients; id++ {
+		for _, b := range Subset(backends, id, subsetSize, hash) {
+			res[b]++
+		}
+	}
+	return res
+}
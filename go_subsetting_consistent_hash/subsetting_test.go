@@ -0,0 +1,122 @@
+package subsetting
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func getServerNodes(nodenum int) []string {
+	nodes := make([]string, 0, nodenum)
+	for i := 0; i < nodenum; i++ {
+		nodes = append(nodes, fmt.Sprintf("127.0.0.1:%d", 8000+i))
+	}
+	return nodes
+}
+
+func minMax(conns map[string]int) (int, int) {
+	min, max := -1, 0
+	for _, c := range conns {
+		if min < 0 || c < min {
+			min = c
+		}
+		if c > max {
+			max = c
+		}
+	}
+	return min, max
+}
+
+func TestSubset(t *testing.T) {
+	backends := getServerNodes(100)
+	// The 10 clients of a round share the backends out.
+	seen := make(map[string]bool)
+	for id := 20; id < 30; id++ {
+		subset := Subset(backends, id, 10, hashString)
+		if len(subset) != 10 {
+			t.Fatalf("expected 10 backends, got %v", subset)
+		}
+		for _, b := range subset {
+			if seen[b] {
+				t.Fatalf("%s is in two subsets of the same round", b)
+			}
+			seen[b] = true
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("expected a round to cover the 100 backends, got %d", len(seen))
+	}
+	if a, b := Subset(backends, 7, 10, hashString), Subset(backends, 17, 10, hashString); fmt.Sprint(a) == fmt.Sprint(b) {
+		t.Errorf("rounds must shuffle differently, got %v twice", a)
+	}
+	if s := Subset(backends[:5], 3, 10, hashString); len(s) != 5 {
+		t.Errorf("expected all 5 backends, got %v", s)
+	}
+	if s := Subset(backends, 3, 0, hashString); s != nil {
+		t.Errorf("expected no backend, got %v", s)
+	}
+	if s := Subset(backends, -1, 10, hashString); s != nil {
+		t.Errorf("expected no backend for a negative client ID, got %v", s)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	for _, v := range []struct {
+		backends, clients, size int
+		maxSpread               int
+	}{
+		{100, 1000, 10, 0},
+		{300, 1000, 20, 1},
+		// 5 backends are left out of each round.
+		{105, 1000, 10, 10},
+		{500, 3000, 25, 0},
+	} {
+		conns := Connections(getServerNodes(v.backends), v.clients, v.size, hashString)
+		min, max := minMax(conns)
+		fmt.Printf("%d backends, %d clients, subsets of %d: %d to %d connections per backend, %f expected\n",
+			v.backends, v.clients, v.size, min, max, float64(v.clients*v.size)/float64(v.backends))
+		if max-min > v.maxSpread {
+			t.Errorf("%d backends: connections range from %d to %d", v.backends, min, max)
+		}
+	}
+}
+
+func TestChurn(t *testing.T) {
+	backends := getServerNodes(105)
+	before := make([][]string, 1000)
+	for id := range before {
+		before[id] = Subset(backends, id, 10, hashString)
+	}
+	changes := func(after []string) map[int]int {
+		res := make(map[int]int)
+		for id := range before {
+			in := make(map[string]bool)
+			for _, b := range before[id] {
+				in[b] = true
+			}
+			n := 0
+			for _, b := range Subset(after, id, 10, hashString) {
+				if !in[b] {
+					n++
+				}
+			}
+			res[n]++
+		}
+		return res
+	}
+	// 10 subsets of 10 backends before and after.
+	added := changes(append(append([]string(nil), backends...), "127.0.0.1:9000"))
+	removed := changes(backends[1:])
+	fmt.Printf("clients by changed backends: %v after adding a backend, %v after removing one\n", added, removed)
+	for _, c := range []map[int]int{added, removed} {
+		if c[0]+c[1] != len(before) {
+			t.Errorf("expected subsets to change by at most one backend, got %v", c)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package consistenthash
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// KeyExtractor returns the part of key that is hashed. Keys with the same
+// extracted part always land on the same node.
+type KeyExtractor func(key string) string
+
+// ErrInvalidHashTag is returned by HashTag for a tag that is not two
+// characters.
+var ErrInvalidHashTag = errors.New("consistenthash: hash tag must be two characters")
+
+// HashTag returns a twemproxy style hash_tag extractor: with tag "{}" the
+// key "user:{42}:cart" hashes "42". The part between the first opening
+// character and the next closing character is used; keys without a
+// closing character or with an empty tag are hashed whole.
+func HashTag(tag string) (KeyExtractor, error) {
+	r := []rune(tag)
+	if len(r) != 2 {
+		return nil, ErrInvalidHashTag
+	}
+	open, close := string(r[0]), string(r[1])
+	return func(key string) string {
+		start := strings.Index(key, open)
+		if start < 0 {
+			return key
+		}
+		start += len(open)
+		end := strings.Index(key[start:], close)
+		if end <= 0 {
+			return key
+		}
+		return key[start : start+end]
+	}, nil
+}
+
+// RegexpExtractor returns an extractor hashing the first submatch of re, or
+// the whole match if re has no group. Keys that do not match, or whose
+// submatch is empty, are hashed whole.
+func RegexpExtractor(re *regexp.Regexp) KeyExtractor {
+	return func(key string) string {
+		m := re.FindStringSubmatch(key)
+		if m == nil {
+			return key
+		}
+		part := m[0]
+		if len(m) > 1 {
+			part = m[1]
+		}
+		if part == "" {
+			return key
+		}
+		return part
+	}
+}
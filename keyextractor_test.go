@@ -0,0 +1,79 @@
+package consistenthash
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestHashTag(t *testing.T) {
+	if _, err := HashTag("{"); err != ErrInvalidHashTag {
+		t.Errorf("expected ErrInvalidHashTag, got %v", err)
+	}
+	if _, err := HashTag("{}}"); err != ErrInvalidHashTag {
+		t.Errorf("expected ErrInvalidHashTag, got %v", err)
+	}
+	extract, _ := HashTag("{}")
+	for key, part := range map[string]string{
+		"user:{42}:profile": "42",
+		"user:{42}:cart":    "42",
+		"{{42}}":            "{42",
+		"a{b{c}d}e":         "b{c",
+		"{42}{43}":          "42",
+		"user:{}:cart":      "user:{}:cart",
+		"user:{42:cart":     "user:{42:cart",
+		"user:42}:cart":     "user:42}:cart",
+		"user:42":           "user:42",
+		"":                  "",
+	} {
+		if got := extract(key); got != part {
+			t.Errorf("expected %q to hash %q, got %q", key, part, got)
+		}
+	}
+
+	// Non ASCII tags work too.
+	extract, _ = HashTag("«»")
+	if got := extract("user:«42»:cart"); got != "42" {
+		t.Errorf("expected 42, got %q", got)
+	}
+}
+
+func TestRegexpExtractor(t *testing.T) {
+	extract := RegexpExtractor(regexp.MustCompile(`^tenant-(\d*)/`))
+	for key, part := range map[string]string{
+		"tenant-7/orders/1": "7",
+		"tenant-/orders/1":  "tenant-/orders/1",
+		"orders/1":          "orders/1",
+	} {
+		if got := extract(key); got != part {
+			t.Errorf("expected %q to hash %q, got %q", key, part, got)
+		}
+	}
+	extract = RegexpExtractor(regexp.MustCompile(`[a-z]+`))
+	if got := extract("42abc7"); got != "abc" {
+		t.Errorf("expected abc, got %q", got)
+	}
+}
+
+func TestPickerKeyExtractor(t *testing.T) {
+	extract, _ := HashTag("{}")
+	nodes := getServerNodes(20)
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, &Options{KeyExtractor: extract})
+		spread := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			id := strconv.Itoa(i)
+			node := p.Lookup("user:{" + id + "}:profile")
+			spread[node] = true
+			if n := p.Lookup("user:{" + id + "}:cart"); n != node {
+				t.Errorf("%s: user %s has its profile on %s and its cart on %s", name, id, node, n)
+			}
+			if ns := p.LookupN("user:{"+id+"}:cart", 2); ns[0] != node {
+				t.Errorf("%s: LookupN does not extract the hash tag", name)
+			}
+		}
+		if len(spread) < len(nodes)/2 {
+			t.Errorf("%s: users only spread over %d nodes", name, len(spread))
+		}
+	}
+}
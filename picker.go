@@ -0,0 +1,351 @@
+// Package consistenthash puts the ketama, rendezvous and jump consistent
+// hashing packages behind a common Picker interface so the algorithm can be
+// chosen by name.
+package consistenthash
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	jump "github.com/shanyux/consistent_hash/go_jump_consistent_hash"
+	ketama "github.com/shanyux/consistent_hash/go_ketama_consistent_hash"
+	rendezvous "github.com/shanyux/consistent_hash/go_rendezvous_consistent_hash"
+	siphash "github.com/shanyux/consistent_hash/go_siphash"
+	splitmix64 "github.com/shanyux/consistent_hash/go_splitmix64"
+)
+
+// Picker maps keys to nodes. Implementations are safe for concurrent use.
+type Picker interface {
+	// Lookup returns the node for key, or "" if there are no nodes.
+	Lookup(key string) string
+	// LookupN returns up to n distinct nodes for key in preference order.
+	// The first one is always Lookup(key).
+	LookupN(key string, n int) []string
+	// Add adds node. Adding an existing node is a no-op.
+	Add(node string)
+	// Remove removes node. Removing an unknown node is a no-op.
+	Remove(node string)
+	// Nodes returns the current nodes.
+	Nodes() []string
+	// Len returns the number of nodes, len(Nodes()) without the copy.
+	Len() int
+}
+
+// Algorithm names accepted by New.
+const (
+	Ketama     = "ketama"
+	Rendezvous = "rendezvous"
+	Jump       = "jump"
+)
+
+// ErrUnknownAlgorithm is returned by New for an unsupported algorithm name.
+var ErrUnknownAlgorithm = errors.New("consistenthash: unknown algorithm")
+
+// Options configures a Picker. The zero value is ready to use.
+type Options struct {
+	// Hash hashes keys and nodes for rendezvous and jump. Ketama uses md5.
+	// Defaults to 64 bit FNV-1a. Ignored if SipHashKey is set.
+	Hash func(s string) uint64
+	// Weights holds the ketama weight of each node. Missing nodes and
+	// other algorithms use weight 1.
+	Weights map[string]uint
+	// KeyExtractor, if set, selects the part of the keys that is hashed.
+	KeyExtractor KeyExtractor
+	// Seed salts the hashing, pickers with different seeds place keys
+	// independently of each other. Seed 0 is the unsalted hashing.
+	Seed uint64
+	// SipHashKey, if set, makes all algorithms hash with SipHash-2-4 under
+	// this secret key, so clients that do not know it cannot craft keys
+	// that all land on the same node. Use a Rotation to change it.
+	SipHashKey *[siphash.KeySize]byte
+}
+
+func (o *Options) hash() func(s string) uint64 {
+	if o == nil {
+		return fnv64a
+	}
+	if o.SipHashKey != nil {
+		return siphash.StringHasher(*o.SipHashKey)
+	}
+	if o.Hash == nil {
+		return fnv64a
+	}
+	return o.Hash
+}
+
+func (o *Options) keyExtractor() KeyExtractor {
+	if o == nil {
+		return nil
+	}
+	return o.KeyExtractor
+}
+
+func (o *Options) seed() uint64 {
+	if o == nil {
+		return 0
+	}
+	return o.Seed
+}
+
+func (o *Options) weight(node string) uint {
+	if o == nil || o.Weights == nil {
+		return 1
+	}
+	if w, ok := o.Weights[node]; ok {
+		return w
+	}
+	return 1
+}
+
+func fnv64a(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// New returns a Picker using the named algorithm. opts may be nil.
+func New(algorithm string, nodes []string, opts *Options) (Picker, error) {
+	switch algorithm {
+	case Ketama:
+		return NewKetama(nodes, opts), nil
+	case Rendezvous:
+		return NewRendezvous(nodes, opts), nil
+	case Jump:
+		return NewJump(nodes, opts), nil
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+type ketamaPicker struct {
+	mu   sync.RWMutex
+	ring *ketama.Ring
+	opts *Options
+}
+
+// NewKetama returns a Picker backed by a ketama.Ring.
+func NewKetama(nodes []string, opts *Options) Picker {
+	realNodes := make([]*ketama.Node, 0, len(nodes))
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		realNodes = append(realNodes, ketama.NewNode(n, nil, opts.weight(n)))
+	}
+	ringOpts := []ketama.Option{ketama.WithSeed(opts.seed())}
+	if e := opts.keyExtractor(); e != nil {
+		ringOpts = append(ringOpts, ketama.WithKeyExtractor(e))
+	}
+	if opts != nil && opts.SipHashKey != nil {
+		ringOpts = append(ringOpts, ketama.WithSipHashKey(*opts.SipHashKey))
+	}
+	return &ketamaPicker{ring: ketama.NewRing(realNodes, ringOpts...), opts: opts}
+}
+
+func (p *ketamaPicker) Lookup(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if n := p.ring.Get(key); n != nil {
+		return n.Key()
+	}
+	return ""
+}
+
+func (p *ketamaPicker) LookupN(key string, n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return nodeKeys(p.ring.GetN(key, n))
+}
+
+func (p *ketamaPicker) Add(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ring.Add(ketama.NewNode(node, nil, p.opts.weight(node)))
+}
+
+func (p *ketamaPicker) Remove(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ring.Remove(node)
+}
+
+func (p *ketamaPicker) Nodes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return nodeKeys(p.ring.Nodes())
+}
+
+func (p *ketamaPicker) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ring.Len()
+}
+
+func nodeKeys(nodes []*ketama.Node) []string {
+	if nodes == nil {
+		return nil
+	}
+	res := make([]string, len(nodes))
+	for i, n := range nodes {
+		res[i] = n.Key()
+	}
+	return res
+}
+
+type rendezvousPicker struct {
+	mu  sync.RWMutex
+	rdz *rendezvous.Rendezvous
+}
+
+// NewRendezvous returns a Picker backed by a rendezvous.Rendezvous.
+func NewRendezvous(nodes []string, opts *Options) Picker {
+	rdzOpts := []rendezvous.Option{rendezvous.WithSeed(opts.seed())}
+	if e := opts.keyExtractor(); e != nil {
+		rdzOpts = append(rdzOpts, rendezvous.WithKeyExtractor(e))
+	}
+	rdz := rendezvous.NewRendezvous(nil, opts.hash(), rdzOpts...)
+	for _, n := range nodes {
+		rdz.Add(n)
+	}
+	return &rendezvousPicker{rdz: rdz}
+}
+
+func (p *rendezvousPicker) Lookup(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rdz.Lookup(key)
+}
+
+func (p *rendezvousPicker) LookupN(key string, n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rdz.LookupN(key, n)
+}
+
+func (p *rendezvousPicker) Add(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rdz.Add(node)
+}
+
+func (p *rendezvousPicker) Remove(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rdz.Remove(node)
+}
+
+func (p *rendezvousPicker) Nodes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rdz.Nodes()
+}
+
+func (p *rendezvousPicker) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rdz.Len()
+}
+
+// jumpPicker maps jump buckets to node names. Jump hashing can only shrink
+// from the end, so Remove moves the last node into the freed bucket: the
+// removed node's keys go to that node and the last bucket's keys are spread
+// over the rest.
+type jumpPicker struct {
+	mu      sync.RWMutex
+	nodes   []string
+	index   map[string]int
+	hash    func(s string) uint64
+	extract KeyExtractor
+	seed    uint64
+}
+
+// NewJump returns a Picker backed by jump.JumpHash.
+func NewJump(nodes []string, opts *Options) Picker {
+	p := &jumpPicker{
+		index:   make(map[string]int, len(nodes)),
+		hash:    opts.hash(),
+		extract: opts.keyExtractor(),
+		seed:    opts.seed(),
+	}
+	for _, n := range nodes {
+		p.add(n)
+	}
+	return p
+}
+
+func (p *jumpPicker) Lookup(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.nodes) == 0 {
+		return ""
+	}
+	return p.nodes[jump.JumpHash(p.keyHash(key), int32(len(p.nodes)))]
+}
+
+func (p *jumpPicker) keyHash(key string) uint64 {
+	if p.extract != nil {
+		key = p.extract(key)
+	}
+	return splitmix64.Seed(p.hash(key), p.seed)
+}
+
+func (p *jumpPicker) LookupN(key string, n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.nodes) == 0 {
+		return nil
+	}
+	buckets := jump.JumpHashN(p.keyHash(key), int32(len(p.nodes)), n)
+	if buckets == nil {
+		return nil
+	}
+	res := make([]string, len(buckets))
+	for i, b := range buckets {
+		res[i] = p.nodes[b]
+	}
+	return res
+}
+
+func (p *jumpPicker) Add(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(node)
+}
+
+func (p *jumpPicker) add(node string) {
+	if _, ok := p.index[node]; ok {
+		return
+	}
+	p.index[node] = len(p.nodes)
+	p.nodes = append(p.nodes, node)
+}
+
+func (p *jumpPicker) Remove(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, ok := p.index[node]
+	if !ok {
+		return
+	}
+	last := len(p.nodes) - 1
+	p.nodes[i] = p.nodes[last]
+	p.index[p.nodes[i]] = i
+	p.nodes = p.nodes[:last]
+	delete(p.index, node)
+}
+
+func (p *jumpPicker) Nodes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make([]string, len(p.nodes))
+	copy(res, p.nodes)
+	return res
+}
+
+func (p *jumpPicker) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.nodes)
+}
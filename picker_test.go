@@ -0,0 +1,174 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+
+	jump "github.com/shanyux/consistent_hash/go_jump_consistent_hash"
+)
+
+var algorithms = []string{Ketama, Rendezvous, Jump}
+
+func TestNewUnknownAlgorithm(t *testing.T) {
+	if _, err := New("maglev2", nil, nil); err != ErrUnknownAlgorithm {
+		t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
+
+func TestPickerEmpty(t *testing.T) {
+	for _, name := range algorithms {
+		p, err := New(name, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := p.Lookup("key"); n != "" {
+			t.Errorf("%s: expected empty lookup, got %q", name, n)
+		}
+		if ns := p.LookupN("key", 3); len(ns) != 0 {
+			t.Errorf("%s: expected no nodes, got %v", name, ns)
+		}
+	}
+}
+
+func TestPickerLookupN(t *testing.T) {
+	nodes := getServerNodes(10)
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, nil)
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			ns := p.LookupN(key, 3)
+			if len(ns) != 3 {
+				t.Fatalf("%s: expected 3 nodes, got %v", name, ns)
+			}
+			if ns[0] != p.Lookup(key) {
+				t.Errorf("%s: LookupN(%s)[0] = %s, Lookup = %s", name, key, ns[0], p.Lookup(key))
+			}
+			if ns[0] == ns[1] || ns[0] == ns[2] || ns[1] == ns[2] {
+				t.Errorf("%s: duplicate nodes %v", name, ns)
+			}
+		}
+		if ns := p.LookupN("key", 20); len(ns) != len(nodes) {
+			t.Errorf("%s: expected %d nodes, got %d", name, len(nodes), len(ns))
+		}
+	}
+}
+
+func TestPickerAddRemove(t *testing.T) {
+	nodes := getServerNodes(10)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, nil)
+		before := make(map[string]string, len(keys))
+		for _, k := range keys {
+			before[k] = p.Lookup(k)
+		}
+
+		// Adding a node only moves keys to it.
+		p.Add("127.0.0.1:9000")
+		p.Add("127.0.0.1:9000")
+		if len(p.Nodes()) != len(nodes)+1 || p.Len() != len(nodes)+1 {
+			t.Fatalf("%s: expected %d nodes, got %v", name, len(nodes)+1, p.Nodes())
+		}
+		for _, k := range keys {
+			if n := p.Lookup(k); n != before[k] && n != "127.0.0.1:9000" {
+				t.Errorf("%s: key %s moved from %s to %s", name, k, before[k], n)
+			}
+		}
+
+		// Removing it again restores the old placement.
+		p.Remove("127.0.0.1:9000")
+		p.Remove("127.0.0.1:9000")
+		for _, k := range keys {
+			if n := p.Lookup(k); n != before[k] {
+				t.Errorf("%s: key %s moved from %s to %s", name, k, before[k], n)
+			}
+		}
+
+		// Removing a node never maps a key to it.
+		p.Remove(nodes[3])
+		for _, k := range keys {
+			if p.Lookup(k) == nodes[3] {
+				t.Errorf("%s: key %s still on removed node", name, k)
+			}
+		}
+	}
+}
+
+func TestPickerWeights(t *testing.T) {
+	nodes := getServerNodes(2)
+	p := NewKetama(nodes, &Options{Weights: map[string]uint{nodes[0]: 3}})
+	count := make(map[string]int)
+	for i := 0; i < 40000; i++ {
+		count[p.Lookup("key"+strconv.Itoa(i))]++
+	}
+	if r := float64(count[nodes[0]]) / float64(count[nodes[1]]); r < 2.4 || r > 3.6 {
+		t.Errorf("expected ~3x keys on the heavy node, got ratio %f", r)
+	}
+}
+
+func TestPickerSeed(t *testing.T) {
+	nodes := getServerNodes(10)
+	testCount := 20000
+	for _, name := range algorithms {
+		p0, _ := New(name, nodes, nil)
+		p1, _ := New(name, nodes, &Options{Seed: 1})
+		p2, _ := New(name, nodes, &Options{Seed: 2})
+		same, unseeded := 0, 0
+		for i := 0; i < testCount; i++ {
+			key := strconv.Itoa(i)
+			if p1.Lookup(key) == p2.Lookup(key) {
+				same++
+			}
+			if p0.Lookup(key) == p1.Lookup(key) {
+				unseeded++
+			}
+		}
+		for _, n := range []int{same, unseeded} {
+			if r := float64(n) / float64(testCount); r < 0.07 || r > 0.13 {
+				t.Errorf("%s: seeded pickers agree on %.3f of the keys, expected about 0.1", name, r)
+			}
+		}
+	}
+}
+
+func TestJumpSeed(t *testing.T) {
+	// The jump picker and the jump package salt the same way, so a seed
+	// places keys alike through both.
+	nodes := getServerNodes(10)
+	p := NewJump(nodes, &Options{Seed: 3})
+	h := jump.New(len(nodes), fnv.New64a(), jump.WithSeed(3))
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if n, b := p.Lookup(key), nodes[h.Hash(key)]; n != b {
+			t.Fatalf("key %s: picker chose %s, jump chose %s", key, n, b)
+		}
+	}
+}
+
+func TestPickerSipHashKey(t *testing.T) {
+	nodes := getServerNodes(10)
+	testCount := 20000
+	for _, name := range algorithms {
+		plain, _ := New(name, nodes, nil)
+		keyed, _ := New(name, nodes, &Options{SipHashKey: &[16]byte{1}})
+		again, _ := New(name, nodes, &Options{SipHashKey: &[16]byte{1}, Hash: fnv64a})
+		unkeyed := 0
+		for i := 0; i < testCount; i++ {
+			key := strconv.Itoa(i)
+			if keyed.Lookup(key) != again.Lookup(key) {
+				t.Fatalf("%s: pickers with the same key disagree on %s", name, key)
+			}
+			if plain.Lookup(key) == keyed.Lookup(key) {
+				unkeyed++
+			}
+		}
+		if r := float64(unkeyed) / float64(testCount); r < 0.07 || r > 0.13 {
+			t.Errorf("%s: keyed and unkeyed pickers agree on %.3f of the keys, expected about 0.1", name, r)
+		}
+	}
+}
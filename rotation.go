@@ -0,0 +1,118 @@
+package consistenthash
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrRotationInProgress is returned by Rotation.Rotate while the previous
+// rotation has not been finished.
+var ErrRotationInProgress = errors.New("consistenthash: rotation in progress")
+
+// Rotation moves keys from one Picker to another, typically built with a
+// new Options.SipHashKey. During a rotation both pickers are kept: Lookup
+// answers from the new one, and LookupAll also returns the node of the old
+// one so callers can fall back to it until the data has been migrated.
+// Rotation is itself a Picker and is safe for concurrent use.
+type Rotation struct {
+	mu       sync.RWMutex
+	current  Picker
+	previous Picker
+}
+
+// NewRotation returns a Rotation that is not rotating and answers from p.
+func NewRotation(p Picker) *Rotation {
+	return &Rotation{current: p}
+}
+
+// Rotate starts a rotation to next, which should hold the same nodes as
+// the current picker.
+func (r *Rotation) Rotate(next Picker) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.previous != nil {
+		return ErrRotationInProgress
+	}
+	r.previous, r.current = r.current, next
+	return nil
+}
+
+// Finish ends the rotation and drops the old picker.
+func (r *Rotation) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.previous = nil
+}
+
+// Rotating reports whether a rotation is in progress.
+func (r *Rotation) Rotating() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.previous != nil
+}
+
+// Lookup returns the node for key under the new picker.
+func (r *Rotation) Lookup(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current.Lookup(key)
+}
+
+// LookupAll returns the node for key under the new picker followed, during
+// a rotation, by its node under the old picker if that is a different one.
+func (r *Rotation) LookupAll(key string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	node := r.current.Lookup(key)
+	if node == "" {
+		return nil
+	}
+	res := []string{node}
+	if r.previous != nil {
+		if old := r.previous.Lookup(key); old != "" && old != node {
+			res = append(res, old)
+		}
+	}
+	return res
+}
+
+// LookupN returns up to n nodes for key under the new picker.
+func (r *Rotation) LookupN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current.LookupN(key, n)
+}
+
+// Add adds node to both pickers.
+func (r *Rotation) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.Add(node)
+	if r.previous != nil {
+		r.previous.Add(node)
+	}
+}
+
+// Remove removes node from both pickers.
+func (r *Rotation) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.Remove(node)
+	if r.previous != nil {
+		r.previous.Remove(node)
+	}
+}
+
+// Nodes returns the nodes of the new picker.
+func (r *Rotation) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current.Nodes()
+}
+
+// Len returns the number of nodes of the new picker.
+func (r *Rotation) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current.Len()
+}
@@ -0,0 +1,64 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRotation(t *testing.T) {
+	nodes := getServerNodes(10)
+	for _, name := range algorithms {
+		oldPicker, _ := New(name, nodes, &Options{SipHashKey: &[16]byte{1}})
+		newPicker, _ := New(name, nodes, &Options{SipHashKey: &[16]byte{2}})
+		r := NewRotation(oldPicker)
+		if r.Rotating() {
+			t.Fatalf("%s: new rotation must not be rotating", name)
+		}
+		if err := r.Rotate(newPicker); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Rotate(oldPicker); err != ErrRotationInProgress {
+			t.Errorf("%s: expected ErrRotationInProgress, got %v", name, err)
+		}
+
+		moved := 0
+		testCount := 10000
+		for i := 0; i < testCount; i++ {
+			key := strconv.Itoa(i)
+			all := r.LookupAll(key)
+			if r.Lookup(key) != newPicker.Lookup(key) || all[0] != newPicker.Lookup(key) {
+				t.Fatalf("%s: %s must be looked up on the new picker", name, key)
+			}
+			if oldPicker.Lookup(key) != newPicker.Lookup(key) {
+				moved++
+				if len(all) != 2 || all[1] != oldPicker.Lookup(key) {
+					t.Fatalf("%s: expected the old node of %s in %v", name, key, all)
+				}
+			} else if len(all) != 1 {
+				t.Fatalf("%s: expected a single node for %s, got %v", name, key, all)
+			}
+		}
+		if f := float64(moved) / float64(testCount); f < 0.85 || f > 0.95 {
+			t.Errorf("%s: rotation moved %.3f of the keys, expected about 0.9", name, f)
+		}
+
+		r.Add("127.0.0.1:9000")
+		if oldPicker.Len() != 11 || r.Len() != 11 {
+			t.Errorf("%s: Add must reach both pickers", name)
+		}
+		r.Remove("127.0.0.1:9000")
+		if oldPicker.Len() != 10 || r.Len() != 10 {
+			t.Errorf("%s: Remove must reach both pickers", name)
+		}
+
+		r.Finish()
+		for i := 0; i < 1000; i++ {
+			if all := r.LookupAll(strconv.Itoa(i)); len(all) != 1 {
+				t.Fatalf("%s: expected a single node after Finish, got %v", name, all)
+			}
+		}
+		if err := r.Rotate(oldPicker); err != nil {
+			t.Errorf("%s: expected a new rotation to start after Finish, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package consistenthash
+
+// LoadSource reports the current load of a node, such as its requests in
+// flight or its latency. It must be safe for concurrent use.
+type LoadSource interface {
+	Load(node string) float64
+}
+
+// LoadFunc adapts a function to a LoadSource.
+type LoadFunc func(node string) float64
+
+// Load returns f(node).
+func (f LoadFunc) Load(node string) float64 {
+	return f(node)
+}
+
+// TwoChoices applies the power of two choices to consistent hashing: every
+// key has two candidate nodes and goes to the less loaded one. Keys keep
+// their affinity to two nodes while hot keys and slow nodes get spread.
+type TwoChoices struct {
+	candidates func(key string) []string
+	loads      LoadSource
+}
+
+// NewTwoChoices returns a TwoChoices taking the first two nodes of
+// p.LookupN as candidates.
+func NewTwoChoices(p Picker, loads LoadSource) *TwoChoices {
+	return &TwoChoices{
+		candidates: func(key string) []string {
+			return p.LookupN(key, 2)
+		},
+		loads: loads,
+	}
+}
+
+// NewTwoChoicesOf returns a TwoChoices taking the nodes of two independent
+// pickers as candidates, for example two rings built with different seeds
+// or hash functions. When both pick the same node it is used, when only one
+// has nodes its pick is.
+func NewTwoChoicesOf(first, second Picker, loads LoadSource) *TwoChoices {
+	return &TwoChoices{
+		candidates: func(key string) []string {
+			a := first.Lookup(key)
+			if b := second.Lookup(key); b != a {
+				return []string{a, b}
+			}
+			return []string{a}
+		},
+		loads: loads,
+	}
+}
+
+// Lookup returns the less loaded candidate of key, the first one on ties,
+// or "" if there are no nodes.
+func (c *TwoChoices) Lookup(key string) string {
+	// A picker without nodes returns "", which is not a candidate.
+	var cands []string
+	for _, n := range c.candidates(key) {
+		if n != "" {
+			cands = append(cands, n)
+		}
+	}
+	switch len(cands) {
+	case 0:
+		return ""
+	case 1:
+		return cands[0]
+	}
+	if c.loads.Load(cands[1]) < c.loads.Load(cands[0]) {
+		return cands[1]
+	}
+	return cands[0]
+}
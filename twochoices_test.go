@@ -0,0 +1,108 @@
+package consistenthash
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+// simulate places keys one after the other, each adding one to the load of
+// its node, and returns the max load.
+func simulate(keys []string, lookup func(key string, load map[string]int) string) int {
+	load := make(map[string]int)
+	peak := 0
+	for _, k := range keys {
+		n := lookup(k, load)
+		load[n]++
+		if load[n] > peak {
+			peak = load[n]
+		}
+	}
+	return peak
+}
+
+func TestTwoChoicesMaxLoad(t *testing.T) {
+	nodes := getServerNodes(20)
+	keys := zipfKeys(20000)
+	for _, name := range algorithms {
+		p, _ := New(name, nodes, nil)
+		plain := simulate(keys, func(key string, load map[string]int) string {
+			return p.Lookup(key)
+		})
+		two := simulate(keys, func(key string, load map[string]int) string {
+			c := NewTwoChoices(p, LoadFunc(func(node string) float64 { return float64(load[node]) }))
+			return c.Lookup(key)
+		})
+		t.Logf("%s: max load %d with Lookup, %d with two choices", name, plain, two)
+		if two >= plain*3/4 {
+			t.Errorf("%s: two choices max load %d is not well below %d", name, two, plain)
+		}
+	}
+}
+
+func TestTwoChoicesOf(t *testing.T) {
+	nodes := getServerNodes(20)
+	keys := zipfKeys(20000)
+	first := NewRendezvous(nodes, nil)
+	second := NewRendezvous(nodes, &Options{Hash: func(s string) uint64 {
+		h := fnv.New64()
+		h.Write([]byte(s))
+		return h.Sum64()
+	}})
+	plain := simulate(keys, func(key string, load map[string]int) string {
+		return first.Lookup(key)
+	})
+	two := simulate(keys, func(key string, load map[string]int) string {
+		c := NewTwoChoicesOf(first, second, LoadFunc(func(node string) float64 { return float64(load[node]) }))
+		return c.Lookup(key)
+	})
+	t.Logf("max load %d with Lookup, %d with two rings", plain, two)
+	if two >= plain*3/4 {
+		t.Errorf("two rings max load %d is not well below %d", two, plain)
+	}
+}
+
+func TestTwoChoicesAffinity(t *testing.T) {
+	nodes := getServerNodes(10)
+	p := NewRendezvous(nodes, nil)
+	idle := LoadFunc(func(node string) float64 { return 0 })
+	c := NewTwoChoices(p, idle)
+	for _, k := range zipfKeys(1000) {
+		if n := c.Lookup(k); n != p.Lookup(k) {
+			t.Fatalf("expected %s to stay on %s when loads are equal, got %s", k, p.Lookup(k), n)
+		}
+	}
+
+	cands := p.LookupN("key", 2)
+	busy := LoadFunc(func(node string) float64 {
+		if node == cands[0] {
+			return 10
+		}
+		return 1
+	})
+	if n := NewTwoChoices(p, busy).Lookup("key"); n != cands[1] {
+		t.Errorf("expected %s, got %s", cands[1], n)
+	}
+	if n := NewTwoChoices(NewRendezvous(nil, nil), idle).Lookup("key"); n != "" {
+		t.Errorf("expected no node, got %q", n)
+	}
+	if n := NewTwoChoices(NewRendezvous(nodes[:1], nil), busy).Lookup("key"); n != nodes[0] {
+		t.Errorf("expected %s, got %q", nodes[0], n)
+	}
+
+	// An empty picker has no candidate, whatever the loads.
+	empty := NewRendezvous(nil, nil)
+	loaded := LoadFunc(func(node string) float64 {
+		if node == "" {
+			return 0
+		}
+		return 10
+	})
+	for _, c := range []*TwoChoices{NewTwoChoicesOf(empty, p, loaded), NewTwoChoicesOf(p, empty, loaded)} {
+		if n := c.Lookup("key"); n != p.Lookup("key") {
+			t.Errorf("expected %s, got %q", p.Lookup("key"), n)
+		}
+	}
+	if n := NewTwoChoicesOf(empty, empty, loaded).Lookup("key"); n != "" {
+		t.Errorf("expected no node, got %q", n)
+	}
+}